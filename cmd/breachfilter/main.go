@@ -0,0 +1,123 @@
+// breachfilter собирает фильтр Блума для pkg/breach из дампа Have I Been Pwned (SHA-1).
+// На вход принимается упорядоченный дамп со строками "HASH:COUNT" либо каталог файлов диапазонов,
+// в котором имя файла - первые 5 символов хэша, а строки содержат "SUFFIX:COUNT"
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"test_auth/pkg/breach"
+)
+
+func main() {
+	var (
+		in       = flag.String("in", "", "HIBP SHA-1 dump file or directory of range files")
+		out      = flag.String("out", "breach.bloom", "output bloom filter file")
+		p        = flag.Float64("p", 0.001, "false positive rate")
+		minCount = flag.Int("min-count", 1, "skip hashes seen in breaches fewer times")
+	)
+	flag.Parse()
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// первый проход считает подходящие хэши, чтобы рассчитать размер фильтра
+	var n uint64
+	err := walk(*in, func(hash string, count int) error {
+		if count >= *minCount {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("read dump error: %s", err)
+	}
+
+	filter, err := breach.NewBloom(n, *p)
+	if err != nil {
+		log.Fatalf("create bloom filter error: %s", err)
+	}
+	err = walk(*in, func(hash string, count int) error {
+		if count >= *minCount {
+			return filter.Add(hash)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("read dump error: %s", err)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("create output file error: %s", err)
+	}
+	size, err := filter.WriteTo(f)
+	if err != nil {
+		log.Fatalf("write bloom filter error: %s", err)
+	}
+	if err = f.Close(); err != nil {
+		log.Fatalf("close output file error: %s", err)
+	}
+	log.Printf("bloom filter with %d hashes written to %s (%d bytes)", n, *out, size)
+}
+
+func walk(path string, fn func(hash string, count int) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return walkFile(path, "", fn)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		prefix := strings.ToUpper(strings.TrimSuffix(e.Name(), ".txt"))
+		if e.IsDir() || len(prefix) != 5 {
+			continue
+		}
+		if err = walkFile(filepath.Join(path, e.Name()), prefix, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkFile(path, prefix string, fn func(hash string, count int) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return scan(f, prefix, fn)
+}
+
+func scan(r io.Reader, prefix string, fn func(hash string, count int) error) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		hash, count, ok := breach.ParseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		hash = prefix + hash
+		if len(hash) != 40 {
+			return fmt.Errorf("line %d: invalid sha1 hash %q", line, hash)
+		}
+		if err := fn(hash, count); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
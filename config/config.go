@@ -13,11 +13,28 @@ type Config struct {
 	Hasher Hasher
 	JWT    JWT
 	SMTP   SMTP
+	Mail   Mail
+
+	IPChange          IPChange
+	EmailVerification EmailVerification
+	PasswordReset     PasswordReset
+	EmailChange       EmailChange
+	MagicLink         MagicLink
+	LoginThrottle     LoginThrottle
+	PasswordPolicy    PasswordPolicy
+	BreachCheck       BreachCheck
+	MFA               MFA
+	WebAuthn          WebAuthn
+	Outbox            Outbox
 }
 
 type (
 	HTTP struct {
 		Port string `env-required:"true" env:"HTTP_PORT"`
+		// TrustedProxies CIDR или адреса прокси, заголовкам которых доверяем; пусто - ip берется из соединения
+		TrustedProxies []string `env:"HTTP_TRUSTED_PROXIES"`
+		// ClientIPHeader единственный заголовок с ip клиента, который выставляют или дополняют наши прокси
+		ClientIPHeader string `env-default:"X-Forwarded-For" env:"HTTP_CLIENT_IP_HEADER"`
 	}
 	Log struct {
 		Level  string `env-required:"true" env:"LOG_LEVEL"`
@@ -28,16 +45,121 @@ type (
 		Url         string `env-required:"true" env:"PG_URL"`
 	}
 	Hasher struct {
-		Secret string `env-required:"true" env:"HASHER_SECRET"`
+		Secret            string            `env-required:"true" env:"HASHER_SECRET"`
+		Peppers           map[string]string `env:"HASHER_PEPPERS"`
+		PepperVersion     int               `env-default:"0" env:"HASHER_PEPPER_VERSION"`
+		Algorithm         string            `env-default:"argon2id" env:"HASHER_ALGORITHM"`
+		Argon2Memory      uint32            `env-default:"65536" env:"HASHER_ARGON2_MEMORY"`
+		Argon2Iterations  uint32            `env-default:"3" env:"HASHER_ARGON2_ITERATIONS"`
+		Argon2Parallelism uint8             `env-default:"2" env:"HASHER_ARGON2_PARALLELISM"`
+		BcryptCost        int               `env-default:"12" env:"HASHER_BCRYPT_COST"`
+		ScryptN           int               `env-default:"32768" env:"HASHER_SCRYPT_N"`
+		ScryptR           int               `env-default:"8" env:"HASHER_SCRYPT_R"`
+		ScryptP           int               `env-default:"1" env:"HASHER_SCRYPT_P"`
 	}
 	JWT struct {
-		SignKey    string        `env-required:"true" env:"JWT_SIGN_KEY"`
+		SignMethod string        `env-default:"HS512" env:"JWT_SIGN_METHOD"`
+		SignKey    string        `env:"JWT_SIGN_KEY"`
+		KeyFile    string        `env:"JWT_KEY_FILE"`
+		KeyId      string        `env:"JWT_KEY_ID"`
+		VerifyKeys []string      `env:"JWT_VERIFY_KEYS"`
 		AccessTTL  time.Duration `env-required:"true" env:"JWT_ACCESS_TTL"`
 		RefreshTTL time.Duration `env-required:"true" env:"JWT_REFRESH_TTL"`
+		Issuer     string        `env-default:"test_auth" env:"JWT_ISSUER"`
+		Audience   string        `env-default:"test_auth" env:"JWT_AUDIENCE"`
+	}
+	IPChange struct {
+		Policy     string        `env-default:"deny-and-notify" env:"IP_CHANGE_POLICY"`
+		SubnetV4   int           `env-default:"24" env:"IP_CHANGE_SUBNET_V4"`
+		SubnetV6   int           `env-default:"64" env:"IP_CHANGE_SUBNET_V6"`
+		KnownIPs   bool          `env-default:"false" env:"IP_CHANGE_KNOWN_IPS"`
+		KnownIPTTL time.Duration `env-default:"2160h" env:"IP_CHANGE_KNOWN_IP_TTL"`
+	}
+	EmailVerification struct {
+		TTL            time.Duration `env-default:"24h" env:"EMAIL_VERIFY_TTL"`
+		ResendInterval time.Duration `env-default:"1m" env:"EMAIL_VERIFY_RESEND_INTERVAL"`
+		Required       bool          `env-default:"false" env:"EMAIL_VERIFY_REQUIRED"`
+		URL            string        `env:"EMAIL_VERIFY_URL"`
+	}
+	PasswordReset struct {
+		TTL            time.Duration `env-default:"30m" env:"PASSWORD_RESET_TTL"`
+		ResendInterval time.Duration `env-default:"1m" env:"PASSWORD_RESET_RESEND_INTERVAL"`
+		URL            string        `env:"PASSWORD_RESET_URL"`
+	}
+	EmailChange struct {
+		TTL time.Duration `env-default:"24h" env:"EMAIL_CHANGE_TTL"`
+		URL string        `env:"EMAIL_CHANGE_URL"`
+	}
+	MagicLink struct {
+		TTL            time.Duration `env-default:"15m" env:"MAGIC_LINK_TTL"`
+		ResendInterval time.Duration `env-default:"1m" env:"MAGIC_LINK_RESEND_INTERVAL"`
+		URL            string        `env:"MAGIC_LINK_URL"`
+		BindIP         bool          `env-default:"false" env:"MAGIC_LINK_BIND_IP"`
+		BindDevice     bool          `env-default:"false" env:"MAGIC_LINK_BIND_DEVICE"`
+	}
+	PasswordPolicy struct {
+		MinLength     int     `env-default:"8" env:"PASSWORD_MIN_LENGTH"`
+		MaxLength     int     `env-default:"128" env:"PASSWORD_MAX_LENGTH"`
+		RequireLower  bool    `env-default:"true" env:"PASSWORD_REQUIRE_LOWER"`
+		RequireUpper  bool    `env-default:"true" env:"PASSWORD_REQUIRE_UPPER"`
+		RequireDigit  bool    `env-default:"true" env:"PASSWORD_REQUIRE_DIGIT"`
+		RequireSymbol bool    `env-default:"false" env:"PASSWORD_REQUIRE_SYMBOL"`
+		MinEntropy    float64 `env-default:"40" env:"PASSWORD_MIN_ENTROPY"`
+	}
+	BreachCheck struct {
+		Mode      string `env-default:"off" env:"BREACH_CHECK_MODE"`
+		BloomFile string `env:"BREACH_BLOOM_FILE"`
+		RangesDir string `env:"BREACH_RANGES_DIR"`
+		MinCount  int    `env-default:"1" env:"BREACH_MIN_COUNT"`
+	}
+	MFA struct {
+		EncryptionKey string        `env:"MFA_ENCRYPTION_KEY"`
+		Issuer        string        `env-default:"test_auth" env:"MFA_ISSUER"`
+		ChallengeTTL  time.Duration `env-default:"5m" env:"MFA_CHALLENGE_TTL"`
+		RecoveryCodes int           `env-default:"10" env:"MFA_RECOVERY_CODES"`
+	}
+	WebAuthn struct {
+		RPID         string        `env:"WEBAUTHN_RP_ID"`
+		RPName       string        `env-default:"test_auth" env:"WEBAUTHN_RP_NAME"`
+		Origins      []string      `env:"WEBAUTHN_ORIGINS"`
+		ChallengeTTL time.Duration `env-default:"5m" env:"WEBAUTHN_CHALLENGE_TTL"`
+	}
+	LoginThrottle struct {
+		Window       time.Duration `env-default:"15m" env:"LOGIN_FAILURE_WINDOW"`
+		DelayAfter   int           `env-default:"3" env:"LOGIN_DELAY_AFTER"`
+		BaseDelay    time.Duration `env-default:"1s" env:"LOGIN_BASE_DELAY"`
+		MaxDelay     time.Duration `env-default:"1m" env:"LOGIN_MAX_DELAY"`
+		LockAfter    int           `env-default:"10" env:"LOGIN_LOCK_AFTER"`
+		IPLockAfter  int           `env-default:"100" env:"LOGIN_IP_LOCK_AFTER"`
+		LockDuration time.Duration `env-default:"15m" env:"LOGIN_LOCK_DURATION"`
 	}
 	SMTP struct {
-		Login    string `env-required:"true" env:"SMTP_LOGIN"`
-		Password string `env-required:"true" env:"SMTP_PASS"`
+		// Transport smtp, maildir, stdout или memory
+		Transport string        `env-default:"smtp" env:"SMTP_TRANSPORT"`
+		Host      string        `env-default:"smtp.gmail.com" env:"SMTP_HOST"`
+		Port      string        `env-default:"587" env:"SMTP_PORT"`
+		TLS       string        `env-default:"starttls" env:"SMTP_TLS"`
+		From      string        `env:"SMTP_FROM"`
+		Auth      string        `env-default:"plain" env:"SMTP_AUTH"`
+		Login     string        `env:"SMTP_LOGIN"`
+		Password  string        `env:"SMTP_PASS"`
+		Timeout   time.Duration `env-default:"10s" env:"SMTP_TIMEOUT"`
+		Maildir   string        `env-default:"maildir" env:"SMTP_MAILDIR"`
+	}
+	Mail struct {
+		// From отправитель в заголовке From, по умолчанию SMTP_FROM или SMTP_LOGIN
+		From          string `env:"MAIL_FROM"`
+		TemplatesDir  string `env:"MAIL_TEMPLATES_DIR"`
+		DefaultLocale string `env-default:"en" env:"MAIL_DEFAULT_LOCALE"`
+	}
+	Outbox struct {
+		PollInterval time.Duration `env-default:"1s" env:"OUTBOX_POLL_INTERVAL"`
+		BatchSize    int           `env-default:"20" env:"OUTBOX_BATCH_SIZE"`
+		Lease        time.Duration `env-default:"1m" env:"OUTBOX_LEASE"`
+		MaxAttempts  int           `env-default:"10" env:"OUTBOX_MAX_ATTEMPTS"`
+		BaseDelay    time.Duration `env-default:"10s" env:"OUTBOX_BASE_DELAY"`
+		MaxDelay     time.Duration `env-default:"1h" env:"OUTBOX_MAX_DELAY"`
+		DrainTimeout time.Duration `env-default:"10s" env:"OUTBOX_DRAIN_TIMEOUT"`
 	}
 )
 
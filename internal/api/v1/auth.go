@@ -1,31 +1,57 @@
 package v1
 
 import (
+	"cmp"
+	"encoding/json"
 	"errors"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strconv"
+	"strings"
 	"test_auth/internal/service"
+	"test_auth/pkg/validator"
 )
 
 type authRouter struct {
-	auth service.Auth
-	user service.User
+	auth    service.Auth
+	user    service.User
+	mfa     service.MFA
+	passkey service.Passkey
 }
 
-func newAuthRouter(g *echo.Group, auth service.Auth, user service.User) {
+func newAuthRouter(g *echo.Group, auth service.Auth, user service.User, mfa service.MFA, passkey service.Passkey) {
 	r := &authRouter{
-		auth: auth,
-		user: user,
+		auth:    auth,
+		user:    user,
+		mfa:     mfa,
+		passkey: passkey,
 	}
 
 	g.POST("/sign-up", r.signUp)
 	g.POST("/sign-in", r.signIn)
+	g.POST("/mfa/verify", r.verifyMFA)
+	g.POST("/mfa/passkey/begin", r.beginMFAPasskey)
+	g.POST("/mfa/passkey/finish", r.finishMFAPasskey)
+	g.POST("/passkey/begin", r.beginPasskeyLogin)
+	g.POST("/passkey/finish", r.finishPasskeyLogin)
+	g.POST("/magic-link", r.sendMagicLink)
+	g.POST("/magic-link/consume", r.consumeMagicLink)
 	g.POST("/refresh", r.refresh)
+	g.POST("/logout", r.logout)
+	g.POST("/logout-all", r.logoutAll)
+	g.POST("/verify-email", r.verifyEmail)
+	g.POST("/verify-email/resend", r.resendVerification)
+	g.POST("/password/forgot", r.forgotPassword)
+	g.POST("/password/reset", r.resetPassword)
+	g.POST("/email/confirm", r.confirmEmailChange)
 }
 
 type signUpInput struct {
 	Email    string `json:"email" validate:"required,email"`
+	Username string `json:"username" validate:"omitempty,username"`
 	Password string `json:"password" validate:"required"`
+	// Locale язык писем, по умолчанию берется из Accept-Language
+	Locale string `json:"locale" validate:"omitempty,locale"`
 }
 
 func (r *authRouter) signUp(c echo.Context) error {
@@ -42,10 +68,13 @@ func (r *authRouter) signUp(c echo.Context) error {
 
 	userId, err := r.user.Create(c.Request().Context(), service.UserCreateInput{
 		Email:    input.Email,
+		Username: input.Username,
 		Password: input.Password,
+		Locale:   cmp.Or(input.Locale, acceptLanguage(c)),
 	})
 	if err != nil {
-		if errors.Is(err, service.ErrUserAlreadyExists) {
+		if errors.Is(err, service.ErrUserAlreadyExists) || errors.Is(err, service.ErrWeakPassword) ||
+			errors.Is(err, service.ErrBreachedPassword) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
 		}
@@ -59,14 +88,43 @@ func (r *authRouter) signUp(c echo.Context) error {
 	return c.JSON(http.StatusCreated, response{UserId: userId})
 }
 
+// acceptLanguage язык с наибольшим весом q из заголовка Accept-Language, например "ru-RU,ru;q=0.9,en;q=0.8".
+// "*" и некорректные значения пропускаются, пустая строка означает язык по умолчанию
+func acceptLanguage(c echo.Context) string {
+	var best string
+	bestQ := 0.0
+	for _, item := range strings.Split(c.Request().Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		if !validator.IsLocale(tag) {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
 type tokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
 
+// signInInput вход по email или username для людей, по user_id для машинных клиентов
 type signInInput struct {
-	UserId   string `json:"user_id" validate:"required"`
+	UserId   string `json:"user_id" validate:"required_without_all=Email Username"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
 	Password string `json:"password" validate:"required"`
+	Device   string `json:"device"`
 }
 
 func (r *authRouter) signIn(c echo.Context) error {
@@ -81,10 +139,19 @@ func (r *authRouter) signIn(c echo.Context) error {
 		return nil
 	}
 
-	ok, err := r.user.Verify(c.Request().Context(), input.UserId, input.Password)
+	userId, ok, err := r.user.Verify(c.Request().Context(), service.UserVerifyInput{
+		UserId:   input.UserId,
+		Email:    input.Email,
+		Username: input.Username,
+		Password: input.Password,
+		IP:       clientIP(c),
+	})
 	if err != nil {
-		if errors.Is(err, service.ErrUserNotFound) {
-			errorResponse(c, http.StatusBadRequest, err)
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			errorResponse(c, http.StatusForbidden, err)
 			return nil
 		}
 		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
@@ -95,7 +162,290 @@ func (r *authRouter) signIn(c echo.Context) error {
 		return nil
 	}
 
-	access, refresh, err := r.auth.CreateTokens(c.Request().Context(), c.Request().RemoteAddr, input.UserId)
+	return r.completeSignIn(c, userId, input.Device)
+}
+
+// completeSignIn выдает токены после первого фактора или mfa токен, если у пользователя включен второй фактор
+func (r *authRouter) completeSignIn(c echo.Context, userId, device string) error {
+	// второй фактор: код из приложения или ключ, который пользователь выбрал вторым фактором при регистрации
+	var methods []string
+	totpEnabled, err := r.mfa.Enabled(c.Request().Context(), userId)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	if totpEnabled {
+		methods = append(methods, "totp")
+	}
+	hasPasskeys, err := r.passkey.SecondFactor(c.Request().Context(), userId)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	if hasPasskeys {
+		methods = append(methods, "passkey")
+	}
+
+	if len(methods) > 0 {
+		challenge, err := r.auth.CreateMFAChallenge(userId, clientIP(c))
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+			return err
+		}
+		type response struct {
+			MFARequired bool     `json:"mfa_required"`
+			MFAToken    string   `json:"mfa_token"`
+			MFAMethods  []string `json:"mfa_methods"`
+		}
+		return c.JSON(http.StatusOK, response{
+			MFARequired: true,
+			MFAToken:    challenge,
+			MFAMethods:  methods,
+		})
+	}
+	return r.createTokens(c, userId, device)
+}
+
+type verifyMFAInput struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+	Device   string `json:"device"`
+}
+
+// verifyMFA второй шаг входа: mfa токен из sign-in и код из приложения (или код восстановления)
+func (r *authRouter) verifyMFA(c echo.Context) error {
+	var input verifyMFAInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	userId, err := r.auth.ValidateMFAChallenge(input.MFAToken, clientIP(c))
+	if err != nil {
+		errorResponse(c, http.StatusUnauthorized, echo.ErrUnauthorized)
+		return nil
+	}
+	if err = r.mfa.Verify(c.Request().Context(), userId, input.Code, clientIP(c)); err != nil {
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) || errors.Is(err, service.ErrMFANotEnabled) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	if ok, err := r.consumeMFAChallenge(c, input.MFAToken); !ok {
+		return err
+	}
+	return r.createTokens(c, userId, input.Device)
+}
+
+type mfaTokenInput struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+// beginMFAPasskey начинает проверку ключа пользователя, прошедшего проверку пароля
+func (r *authRouter) beginMFAPasskey(c echo.Context) error {
+	var input mfaTokenInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	userId, err := r.auth.ValidateMFAChallenge(input.MFAToken, clientIP(c))
+	if err != nil {
+		errorResponse(c, http.StatusUnauthorized, echo.ErrUnauthorized)
+		return nil
+	}
+	out, err := r.passkey.BeginLogin(c.Request().Context(), userId)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusOK, passkeyChallengeResponse{
+		ChallengeId: out.ChallengeId,
+		Options:     out.Options,
+	})
+}
+
+type finishMFAPasskeyInput struct {
+	MFAToken    string          `json:"mfa_token" validate:"required"`
+	ChallengeId string          `json:"challenge_id" validate:"required"`
+	Credential  json.RawMessage `json:"credential" validate:"required"`
+	Device      string          `json:"device"`
+}
+
+func (r *authRouter) finishMFAPasskey(c echo.Context) error {
+	var input finishMFAPasskeyInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	userId, err := r.auth.ValidateMFAChallenge(input.MFAToken, clientIP(c))
+	if err != nil {
+		errorResponse(c, http.StatusUnauthorized, echo.ErrUnauthorized)
+		return nil
+	}
+	_, err = r.passkey.FinishLogin(c.Request().Context(), service.PasskeyLoginInput{
+		UserId:      userId,
+		ChallengeId: input.ChallengeId,
+		Credential:  input.Credential,
+	})
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	if ok, err := r.consumeMFAChallenge(c, input.MFAToken); !ok {
+		return err
+	}
+	return r.createTokens(c, userId, input.Device)
+}
+
+// consumeMFAChallenge после успешной проверки второго фактора mfa токен нельзя использовать повторно.
+// Возвращает false, если ответ уже отправлен
+func (r *authRouter) consumeMFAChallenge(c echo.Context, token string) (bool, error) {
+	if err := r.auth.ConsumeMFAChallenge(c.Request().Context(), token); err != nil {
+		if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrUnknownSignKey) ||
+			errors.Is(err, service.ErrInvalidTokenType) {
+			errorResponse(c, http.StatusUnauthorized, echo.ErrUnauthorized)
+			return false, nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return false, err
+	}
+	return true, nil
+}
+
+// beginPasskeyLogin вход без пароля: браузер сам предлагает ключи, сохраненные для сервиса
+func (r *authRouter) beginPasskeyLogin(c echo.Context) error {
+	out, err := r.passkey.BeginLogin(c.Request().Context(), "")
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusOK, passkeyChallengeResponse{
+		ChallengeId: out.ChallengeId,
+		Options:     out.Options,
+	})
+}
+
+type finishPasskeyLoginInput struct {
+	ChallengeId string          `json:"challenge_id" validate:"required"`
+	Credential  json.RawMessage `json:"credential" validate:"required"`
+	Device      string          `json:"device"`
+}
+
+func (r *authRouter) finishPasskeyLogin(c echo.Context) error {
+	var input finishPasskeyLoginInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	userId, err := r.passkey.FinishLogin(c.Request().Context(), service.PasskeyLoginInput{
+		ChallengeId: input.ChallengeId,
+		Credential:  input.Credential,
+	})
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return r.createTokens(c, userId, input.Device)
+}
+
+type magicLinkInput struct {
+	Email  string `json:"email" validate:"required"`
+	Device string `json:"device"`
+}
+
+// sendMagicLink ответ не зависит от того, зарегистрирован ли адрес
+func (r *authRouter) sendMagicLink(c echo.Context) error {
+	var input magicLinkInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	err := r.user.SendMagicLink(c.Request().Context(), service.MagicLinkInput{
+		Email:     input.Email,
+		IP:        clientIP(c),
+		UserAgent: c.Request().UserAgent(),
+		Device:    input.Device,
+	})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+type consumeMagicLinkInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *authRouter) consumeMagicLink(c echo.Context) error {
+	var input consumeMagicLinkInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	userId, device, err := r.user.ConsumeMagicLink(c.Request().Context(), service.MagicLinkConsumeInput{
+		Token:     input.Token,
+		IP:        clientIP(c),
+		UserAgent: c.Request().UserAgent(),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidUserToken) || errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrMagicLinkBinding) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return r.completeSignIn(c, userId, device)
+}
+
+func (r *authRouter) createTokens(c echo.Context, userId, device string) error {
+	access, refresh, err := r.auth.CreateTokens(c.Request().Context(), service.TokenCreateInput{
+		UserId:    userId,
+		IP:        clientIP(c),
+		UserAgent: c.Request().UserAgent(),
+		Device:    device,
+	})
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
 		return err
@@ -107,7 +457,8 @@ func (r *authRouter) signIn(c echo.Context) error {
 }
 
 type refreshInput struct {
-	Token string `json:"token" validate:"required"`
+	AccessToken string `json:"access_token" validate:"required"`
+	Token       string `json:"token" validate:"required"`
 }
 
 func (r *authRouter) refresh(c echo.Context) error {
@@ -122,7 +473,7 @@ func (r *authRouter) refresh(c echo.Context) error {
 		return nil
 	}
 
-	access, refresh, err := r.auth.RefreshToken(c.Request().Context(), c.Request().RemoteAddr, input.Token)
+	access, refresh, err := r.auth.RefreshToken(c.Request().Context(), clientIP(c), input.AccessToken, input.Token)
 	if err != nil {
 		if errors.Is(err, service.ErrCannotRefreshToken) {
 			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
@@ -137,3 +488,183 @@ func (r *authRouter) refresh(c echo.Context) error {
 		RefreshToken: refresh,
 	})
 }
+
+type logoutInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *authRouter) logout(c echo.Context) error {
+	var input logoutInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.auth.Logout(c.Request().Context(), input.Token); err != nil {
+		if errors.Is(err, service.ErrCannotRevokeSession) {
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+			return err
+		}
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (r *authRouter) logoutAll(c echo.Context) error {
+	var input logoutInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.auth.LogoutAll(c.Request().Context(), input.Token); err != nil {
+		if errors.Is(err, service.ErrCannotRevokeSession) {
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+			return err
+		}
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type verifyEmailInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *authRouter) verifyEmail(c echo.Context) error {
+	var input verifyEmailInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.user.VerifyEmail(c.Request().Context(), input.Token); err != nil {
+		if errors.Is(err, service.ErrInvalidUserToken) || errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type resendVerificationInput struct {
+	Email string `json:"email" validate:"required"`
+}
+
+func (r *authRouter) resendVerification(c echo.Context) error {
+	var input resendVerificationInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.user.SendVerification(c.Request().Context(), input.Email); err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+type forgotPasswordInput struct {
+	Email string `json:"email" validate:"required"`
+}
+
+func (r *authRouter) forgotPassword(c echo.Context) error {
+	var input forgotPasswordInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.user.ForgotPassword(c.Request().Context(), input.Email); err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+type resetPasswordInput struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *authRouter) resetPassword(c echo.Context) error {
+	var input resetPasswordInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.user.ResetPassword(c.Request().Context(), input.Token, input.Password); err != nil {
+		if errors.Is(err, service.ErrInvalidUserToken) || errors.Is(err, service.ErrUserNotFound) ||
+			errors.Is(err, service.ErrWeakPassword) || errors.Is(err, service.ErrBreachedPassword) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type confirmEmailChangeInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *authRouter) confirmEmailChange(c echo.Context) error {
+	var input confirmEmailChangeInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.user.ConfirmEmailChange(c.Request().Context(), input.Token); err != nil {
+		if errors.Is(err, service.ErrInvalidUserToken) || errors.Is(err, service.ErrUserAlreadyExists) ||
+			errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
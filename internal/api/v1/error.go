@@ -3,6 +3,10 @@ package v1
 import (
 	"errors"
 	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+	"strconv"
+	"test_auth/internal/service"
 )
 
 func errorResponse(c echo.Context, status int, err error) {
@@ -12,3 +16,14 @@ func errorResponse(c echo.Context, status int, err error) {
 	}
 	_ = c.JSON(status, err)
 }
+
+// retryResponse отвечает 429 с заголовком Retry-After, если err содержит service.RetryError
+func retryResponse(c echo.Context, err error) bool {
+	var retry *service.RetryError
+	if !errors.As(err, &retry) {
+		return false
+	}
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+	errorResponse(c, http.StatusTooManyRequests, err)
+	return true
+}
@@ -0,0 +1,212 @@
+package v1
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"test_auth/internal/service"
+	"time"
+)
+
+type meRouter struct {
+	auth service.Auth
+	user service.User
+}
+
+func newMeRouter(g *echo.Group, auth service.Auth, user service.User) {
+	r := &meRouter{
+		auth: auth,
+		user: user,
+	}
+
+	g.GET("", r.me)
+	g.GET("/sessions", r.sessions)
+	g.PUT("/password", r.changePassword)
+	g.PUT("/email", r.changeEmail)
+	g.PUT("/locale", r.changeLocale)
+}
+
+func (r *meRouter) me(c echo.Context) error {
+	claims := userClaims(c)
+
+	u, err := r.user.Find(c.Request().Context(), claims.UserId)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	type response struct {
+		UserId    string `json:"user_id"`
+		Email     string `json:"email"`
+		Locale    string `json:"locale"`
+		SessionId string `json:"session_id"`
+	}
+	return c.JSON(http.StatusOK, response{
+		UserId:    u.UserId,
+		Email:     u.Email,
+		Locale:    u.Locale,
+		SessionId: claims.SessionId,
+	})
+}
+
+type sessionResponse struct {
+	SessionId   string    `json:"session_id"`
+	Device      string    `json:"device"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Current     bool      `json:"current"`
+}
+
+func (r *meRouter) sessions(c echo.Context) error {
+	claims := userClaims(c)
+
+	sessions, err := r.auth.Sessions(c.Request().Context(), claims.UserId)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, sessionResponse{
+			SessionId:   s.SessionId,
+			Device:      s.Device,
+			IP:          s.IP,
+			UserAgent:   s.UserAgent,
+			CreatedAt:   s.CreatedAt,
+			RefreshedAt: s.RefreshedAt,
+			ExpiresAt:   s.ExpiresAt,
+			Current:     s.SessionId == claims.SessionId,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+type changePasswordInput struct {
+	CurrentPassword     string `json:"current_password" validate:"required"`
+	NewPassword         string `json:"new_password" validate:"required"`
+	RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+}
+
+func (r *meRouter) changePassword(c echo.Context) error {
+	var input changePasswordInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	err := r.user.ChangePassword(c.Request().Context(), service.UserChangePasswordInput{
+		UserId:              claims.UserId,
+		SessionId:           claims.SessionId,
+		CurrentPassword:     input.CurrentPassword,
+		NewPassword:         input.NewPassword,
+		IP:                  clientIP(c),
+		RevokeOtherSessions: input.RevokeOtherSessions,
+	})
+	if err != nil {
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidPassword) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrWeakPassword) || errors.Is(err, service.ErrBreachedPassword) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type changeEmailInput struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *meRouter) changeEmail(c echo.Context) error {
+	var input changeEmailInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	err := r.user.ChangeEmail(c.Request().Context(), service.UserChangeEmailInput{
+		UserId:   userClaims(c).UserId,
+		NewEmail: input.Email,
+		Password: input.Password,
+		IP:       clientIP(c),
+	})
+	if err != nil {
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidPassword) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrUserAlreadyExists) || errors.Is(err, service.ErrSameEmail) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// changeLocaleInput пустой locale возвращает письма на языке по умолчанию
+type changeLocaleInput struct {
+	Locale string `json:"locale" validate:"omitempty,locale"`
+}
+
+func (r *meRouter) changeLocale(c echo.Context) error {
+	var input changeLocaleInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.user.ChangeLocale(c.Request().Context(), userClaims(c).UserId, input.Locale); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,163 @@
+package v1
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"test_auth/internal/service"
+)
+
+type mfaRouter struct {
+	mfa service.MFA
+}
+
+func newMFARouter(g *echo.Group, mfa service.MFA) {
+	r := &mfaRouter{
+		mfa: mfa,
+	}
+
+	g.POST("/totp", r.enrollTOTP)
+	g.POST("/totp/confirm", r.confirmTOTP)
+	g.DELETE("/totp", r.disableTOTP)
+}
+
+type enrollTOTPInput struct {
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *mfaRouter) enrollTOTP(c echo.Context) error {
+	var input enrollTOTPInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	out, err := r.mfa.EnrollTOTP(c.Request().Context(), service.TOTPEnrollInput{
+		UserId:   claims.UserId,
+		Password: input.Password,
+		IP:       clientIP(c),
+	})
+	if err != nil {
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidPassword) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrMFAAlreadyEnabled) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrMFANotConfigured) {
+			errorResponse(c, http.StatusNotImplemented, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	type response struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	return c.JSON(http.StatusOK, response{
+		Secret: out.Secret,
+		URI:    out.URI,
+	})
+}
+
+type mfaCodeInput struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type confirmTOTPInput struct {
+	Code     string `json:"code" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *mfaRouter) confirmTOTP(c echo.Context) error {
+	var input confirmTOTPInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	codes, err := r.mfa.ConfirmTOTP(c.Request().Context(), service.TOTPConfirmInput{
+		UserId:   claims.UserId,
+		Code:     input.Code,
+		Password: input.Password,
+		IP:       clientIP(c),
+	})
+	if err != nil {
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidPassword) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) || errors.Is(err, service.ErrMFANotEnabled) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrMFAAlreadyEnabled) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	return c.JSON(http.StatusOK, response{RecoveryCodes: codes})
+}
+
+func (r *mfaRouter) disableTOTP(c echo.Context) error {
+	var input mfaCodeInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	if err := r.mfa.DisableTOTP(c.Request().Context(), claims.UserId, input.Code, clientIP(c)); err != nil {
+		if retryResponse(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrMFANotEnabled) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
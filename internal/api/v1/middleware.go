@@ -1,10 +1,22 @@
 package v1
 
 import (
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"log"
+	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"test_auth/internal/service"
+	"test_auth/pkg/clientip"
+)
+
+const (
+	userClaimsCtx = "userClaims"
+	clientIPCtx   = "clientIP"
+	bearerPrefix  = "Bearer "
 )
 
 func LoggingMiddleware(h *echo.Echo, output string) {
@@ -22,3 +34,59 @@ func LoggingMiddleware(h *echo.Echo, output string) {
 	}
 	h.Use(middleware.LoggerWithConfig(cfg))
 }
+
+// ClientIPMiddleware один раз на запрос определяет ip клиента с учетом доверенных прокси
+func ClientIPMiddleware(h *echo.Echo, resolver *clientip.Resolver) {
+	h.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ip, err := resolver.ClientIP(c.Request())
+			if err != nil {
+				errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+				return nil
+			}
+			c.Set(clientIPCtx, ip)
+			return next(c)
+		}
+	})
+}
+
+// AuthMiddleware проверяет access токен из заголовка Authorization и его сессию, claims кладутся в контекст запроса
+func AuthMiddleware(auth service.Auth) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+				errorResponse(c, http.StatusUnauthorized, echo.ErrUnauthorized)
+				return nil
+			}
+
+			claims, err := auth.ValidateAccessToken(header[len(bearerPrefix):])
+			if err != nil {
+				errorResponse(c, http.StatusUnauthorized, err)
+				return nil
+			}
+			// без проверки сессии access токен работал бы после выхода и сброса пароля до истечения своего срока
+			if err = auth.ValidateSession(c.Request().Context(), claims); err != nil {
+				if errors.Is(err, service.ErrSessionNotFound) || errors.Is(err, service.ErrSessionRevoked) ||
+					errors.Is(err, service.ErrSessionExpired) || errors.Is(err, service.ErrInvalidToken) {
+					errorResponse(c, http.StatusUnauthorized, err)
+					return nil
+				}
+				errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+				return err
+			}
+			c.Set(userClaimsCtx, claims)
+			return next(c)
+		}
+	}
+}
+
+func userClaims(c echo.Context) *service.TokenClaims {
+	claims, _ := c.Get(userClaimsCtx).(*service.TokenClaims)
+	return claims
+}
+
+func clientIP(c echo.Context) netip.Addr {
+	ip, _ := c.Get(clientIPCtx).(netip.Addr)
+	return ip
+}
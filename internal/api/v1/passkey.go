@@ -0,0 +1,184 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"test_auth/internal/service"
+	"time"
+)
+
+type passkeyRouter struct {
+	passkey service.Passkey
+}
+
+func newPasskeyRouter(g *echo.Group, passkey service.Passkey) {
+	r := &passkeyRouter{
+		passkey: passkey,
+	}
+
+	g.GET("", r.list)
+	g.POST("/register/begin", r.beginRegistration)
+	g.POST("/register/finish", r.finishRegistration)
+	g.DELETE("/:id", r.delete)
+}
+
+type passkeyChallengeResponse struct {
+	ChallengeId string          `json:"challenge_id"`
+	Options     json.RawMessage `json:"options"`
+}
+
+// passkeyError ответы на ошибки церемоний webauthn, общие для регистрации и входа
+func passkeyError(c echo.Context, err error) error {
+	if retryResponse(c, err) {
+		return nil
+	}
+	switch {
+	case errors.Is(err, service.ErrInvalidPassword), errors.Is(err, service.ErrEmailNotVerified):
+		errorResponse(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrInvalidPasskey):
+		errorResponse(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrPasskeyNotFound), errors.Is(err, service.ErrUserNotFound):
+		errorResponse(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrPasskeyAlreadyExists):
+		errorResponse(c, http.StatusConflict, err)
+	case errors.Is(err, service.ErrPasskeyNotConfigured):
+		errorResponse(c, http.StatusNotImplemented, err)
+	default:
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return nil
+}
+
+func (r *passkeyRouter) list(c echo.Context) error {
+	claims := userClaims(c)
+
+	passkeys, err := r.passkey.List(c.Request().Context(), claims.UserId)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	type passkeyResponse struct {
+		Id           int        `json:"id"`
+		Name         string     `json:"name"`
+		Synced       bool       `json:"synced"`
+		SecondFactor bool       `json:"second_factor"`
+		CreatedAt    time.Time  `json:"created_at"`
+		LastUsedAt   *time.Time `json:"last_used_at"`
+	}
+	response := make([]passkeyResponse, 0, len(passkeys))
+	for _, p := range passkeys {
+		response = append(response, passkeyResponse{
+			Id:           p.Id,
+			Name:         p.Name,
+			Synced:       p.Synced,
+			SecondFactor: p.SecondFactor,
+			CreatedAt:    p.CreatedAt,
+			LastUsedAt:   p.LastUsedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// passwordInput ключ дает вход без пароля, поэтому его добавление и удаление подтверждается текущим паролем
+type passwordInput struct {
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *passkeyRouter) beginRegistration(c echo.Context) error {
+	var input passwordInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	out, err := r.passkey.BeginRegistration(c.Request().Context(), service.PasskeyBeginInput{
+		UserId:   claims.UserId,
+		Password: input.Password,
+		IP:       clientIP(c),
+	})
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusOK, passkeyChallengeResponse{
+		ChallengeId: out.ChallengeId,
+		Options:     out.Options,
+	})
+}
+
+type finishRegistrationInput struct {
+	ChallengeId string          `json:"challenge_id" validate:"required"`
+	Name        string          `json:"name"`
+	Credential  json.RawMessage `json:"credential" validate:"required"`
+	Password    string          `json:"password" validate:"required"`
+	// SecondFactor запрашивать ключ после пароля при входе
+	SecondFactor bool `json:"second_factor"`
+}
+
+func (r *passkeyRouter) finishRegistration(c echo.Context) error {
+	var input finishRegistrationInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	err := r.passkey.FinishRegistration(c.Request().Context(), service.PasskeyRegisterInput{
+		UserId:      claims.UserId,
+		ChallengeId: input.ChallengeId,
+		Name:        input.Name,
+		Credential:  input.Credential,
+		Password:    input.Password,
+		IP:          clientIP(c),
+
+		SecondFactor: input.SecondFactor,
+	})
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.NoContent(http.StatusCreated)
+}
+
+type deletePasskeyInput struct {
+	Id       int    `param:"id" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *passkeyRouter) delete(c echo.Context) error {
+	var input deletePasskeyInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	claims := userClaims(c)
+
+	err := r.passkey.Delete(c.Request().Context(), service.PasskeyDeleteInput{
+		UserId:   claims.UserId,
+		Id:       input.Id,
+		Password: input.Password,
+		IP:       clientIP(c),
+	})
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -9,9 +9,15 @@ import (
 func NewRouter(h *echo.Echo, services *service.Services) {
 	h.Use(middleware.Recover())
 	h.GET("/ping", ping)
+	newWellKnownRouter(h.Group("/.well-known"), services.Auth)
 
 	v1 := h.Group("/api/v1")
-	newAuthRouter(v1.Group("/auth"), services.Auth, services.User)
+	newAuthRouter(v1.Group("/auth"), services.Auth, services.User, services.MFA, services.Passkey)
+	newSessionRouter(v1.Group("/sessions"), services.Auth)
+	meGroup := v1.Group("/me", AuthMiddleware(services.Auth))
+	newMeRouter(meGroup, services.Auth, services.User)
+	newMFARouter(meGroup.Group("/mfa"), services.MFA)
+	newPasskeyRouter(meGroup.Group("/passkeys"), services.Passkey)
 }
 
 func ping(c echo.Context) error {
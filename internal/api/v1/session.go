@@ -0,0 +1,52 @@
+package v1
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"test_auth/internal/service"
+)
+
+type sessionRouter struct {
+	auth service.Auth
+}
+
+func newSessionRouter(g *echo.Group, auth service.Auth) {
+	r := &sessionRouter{
+		auth: auth,
+	}
+
+	g.DELETE("/:id", r.revoke)
+}
+
+type revokeSessionInput struct {
+	SessionId string `param:"id" validate:"required"`
+	Token     string `json:"token" validate:"required"`
+}
+
+func (r *sessionRouter) revoke(c echo.Context) error {
+	var input revokeSessionInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return nil
+	}
+	if err := c.Validate(input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	if err := r.auth.RevokeSession(c.Request().Context(), input.Token, input.SessionId); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrCannotRevokeSession) {
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+			return err
+		}
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,27 @@
+package v1
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"test_auth/internal/service"
+)
+
+// ресурсные серверы кэшируют jwks, новый ключ должен появиться здесь раньше, чем им начнут подписывать токены
+const jwksCacheControl = "public, max-age=300"
+
+type wellKnownRouter struct {
+	auth service.Auth
+}
+
+func newWellKnownRouter(g *echo.Group, auth service.Auth) {
+	r := &wellKnownRouter{
+		auth: auth,
+	}
+
+	g.GET("/jwks.json", r.jwks)
+}
+
+func (r *wellKnownRouter) jwks(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, jwksCacheControl)
+	return c.JSON(http.StatusOK, r.auth.JWKS())
+}
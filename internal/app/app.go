@@ -1,21 +1,35 @@
 package app
 
 import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
+	netmail "net/mail"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"test_auth/config"
 	v1 "test_auth/internal/api/v1"
 	"test_auth/internal/repo"
 	"test_auth/internal/service"
+	"test_auth/pkg/aesgcm"
+	"test_auth/pkg/breach"
+	"test_auth/pkg/clientip"
 	"test_auth/pkg/hasher"
 	"test_auth/pkg/httpserver"
+	"test_auth/pkg/mail"
 	"test_auth/pkg/postgres"
+	"test_auth/pkg/signkey"
 	"test_auth/pkg/smtp"
 	"test_auth/pkg/validator"
+	"unicode/utf8"
 )
 
 func Run() {
@@ -34,15 +48,144 @@ func Run() {
 	}
 	defer pg.Close()
 
+	// jwt sign keys
+	keys, err := loadKeyRing(cfg.JWT)
+	if err != nil {
+		log.Fatalf("Loading jwt sign keys error: %s", err)
+	}
+
+	// password hasher
+	peppers, err := parsePeppers(cfg.Hasher.Peppers)
+	if err != nil {
+		log.Fatalf("Config error: %s", err)
+	}
+	h, err := hasher.NewHasher(cfg.Hasher.Secret,
+		hasher.Peppers(cfg.Hasher.PepperVersion, peppers),
+		hasher.Algorithm(cfg.Hasher.Algorithm),
+		hasher.Argon2Params(cfg.Hasher.Argon2Memory, cfg.Hasher.Argon2Iterations, cfg.Hasher.Argon2Parallelism),
+		hasher.BcryptCost(cfg.Hasher.BcryptCost),
+		hasher.ScryptParams(cfg.Hasher.ScryptN, cfg.Hasher.ScryptR, cfg.Hasher.ScryptP),
+	)
+	if err != nil {
+		log.Fatalf("Initializing password hasher error: %s", err)
+	}
+
+	// password policy for new passwords
+	if cfg.PasswordPolicy.MaxLength <= 0 || cfg.PasswordPolicy.MaxLength*utf8.UTFMax > hasher.MaxPasswordLength {
+		log.Fatalf("Config error: PASSWORD_MAX_LENGTH must be between 1 and %d", hasher.MaxPasswordLength/utf8.UTFMax)
+	}
+
+	// local breached passwords dataset
+	breachCheck, err := loadBreachCheck(cfg.BreachCheck)
+	if err != nil {
+		log.Fatalf("Loading breached passwords error: %s", err)
+	}
+
+	// encryption of totp secrets
+	mfaCipher, err := loadMFACipher(cfg.MFA.EncryptionKey)
+	if err != nil {
+		log.Fatalf("Config error: %s", err)
+	}
+
+	// mail transport
+	mailer, err := newMailer(cfg.SMTP)
+	if err != nil {
+		log.Fatalf("Initializing mail transport error: %s", err)
+	}
+
+	// mail templates
+	mailConfig, err := loadMail(cfg.Mail, cfg.SMTP)
+	if err != nil {
+		log.Fatalf("Loading mail templates error: %s", err)
+	}
+
+	// refresh from another ip
+	ipChange, err := loadIPChange(cfg.IPChange)
+	if err != nil {
+		log.Fatalf("Config error: %s", err)
+	}
+
+	// webauthn relying party
+	rp, err := newRelyingParty(cfg.WebAuthn)
+	if err != nil {
+		log.Fatalf("Config error: %s", err)
+	}
+
 	d := &service.ServicesDependencies{
 		Repos:      repo.NewRepositories(pg),
-		Smtp:       smtp.NewSmtp(cfg.SMTP.Login, cfg.SMTP.Password),
-		Hasher:     hasher.NewHasher(cfg.Hasher.Secret),
-		SignKey:    cfg.JWT.SignKey,
+		Smtp:       mailer,
+		Hasher:     h,
+		Keys:       keys,
 		AccessTTL:  cfg.JWT.AccessTTL,
 		RefreshTTL: cfg.JWT.RefreshTTL,
+		Issuer:     cfg.JWT.Issuer,
+		Audience:   cfg.JWT.Audience,
+		IPChange:   ipChange,
+
+		EmailVerification: service.EmailVerificationConfig{
+			TTL:            cfg.EmailVerification.TTL,
+			ResendInterval: cfg.EmailVerification.ResendInterval,
+			Required:       cfg.EmailVerification.Required,
+			URL:            cfg.EmailVerification.URL,
+		},
+		PasswordReset: service.PasswordResetConfig{
+			TTL:            cfg.PasswordReset.TTL,
+			ResendInterval: cfg.PasswordReset.ResendInterval,
+			URL:            cfg.PasswordReset.URL,
+		},
+		EmailChange: service.EmailChangeConfig{
+			TTL: cfg.EmailChange.TTL,
+			URL: cfg.EmailChange.URL,
+		},
+		MagicLink: service.MagicLinkConfig{
+			TTL:            cfg.MagicLink.TTL,
+			ResendInterval: cfg.MagicLink.ResendInterval,
+			URL:            cfg.MagicLink.URL,
+			BindIP:         cfg.MagicLink.BindIP,
+			BindDevice:     cfg.MagicLink.BindDevice,
+		},
+		PasswordPolicy: validator.PasswordPolicy{
+			MinLength:     cfg.PasswordPolicy.MinLength,
+			MaxLength:     cfg.PasswordPolicy.MaxLength,
+			RequireLower:  cfg.PasswordPolicy.RequireLower,
+			RequireUpper:  cfg.PasswordPolicy.RequireUpper,
+			RequireDigit:  cfg.PasswordPolicy.RequireDigit,
+			RequireSymbol: cfg.PasswordPolicy.RequireSymbol,
+			MinEntropy:    cfg.PasswordPolicy.MinEntropy,
+		},
+		BreachCheck: breachCheck,
+		MFA: service.MFAConfig{
+			Cipher:        mfaCipher,
+			Issuer:        cfg.MFA.Issuer,
+			ChallengeTTL:  cfg.MFA.ChallengeTTL,
+			RecoveryCodes: cfg.MFA.RecoveryCodes,
+		},
+		Passkey: service.PasskeyConfig{
+			RelyingParty: rp,
+			ChallengeTTL: cfg.WebAuthn.ChallengeTTL,
+		},
+		LoginThrottle: service.LoginThrottleConfig{
+			Window:       cfg.LoginThrottle.Window,
+			DelayAfter:   cfg.LoginThrottle.DelayAfter,
+			BaseDelay:    cfg.LoginThrottle.BaseDelay,
+			MaxDelay:     cfg.LoginThrottle.MaxDelay,
+			LockAfter:    cfg.LoginThrottle.LockAfter,
+			IPLockAfter:  cfg.LoginThrottle.IPLockAfter,
+			LockDuration: cfg.LoginThrottle.LockDuration,
+		},
+		Mail: mailConfig,
+		Outbox: service.OutboxConfig{
+			PollInterval: cfg.Outbox.PollInterval,
+			BatchSize:    cfg.Outbox.BatchSize,
+			Lease:        cfg.Outbox.Lease,
+			MaxAttempts:  cfg.Outbox.MaxAttempts,
+			BaseDelay:    cfg.Outbox.BaseDelay,
+			MaxDelay:     cfg.Outbox.MaxDelay,
+			DrainTimeout: cfg.Outbox.DrainTimeout,
+		},
 	}
 	services := service.NewServices(d)
+	services.Outbox.Start()
 
 	// validator for incoming requests
 	v, err := validator.NewValidator()
@@ -50,10 +193,21 @@ func Run() {
 		log.Fatalf("Initializing handler validator error: %s", err)
 	}
 
+	// client ip behind trusted proxies
+	trustedProxies, err := clientip.ParsePrefixes(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Parsing trusted proxies error: %s", err)
+	}
+	resolver, err := clientip.New(clientip.TrustedProxies(trustedProxies...), clientip.Header(cfg.HTTP.ClientIPHeader))
+	if err != nil {
+		log.Fatalf("Initializing client ip resolver error: %s", err)
+	}
+
 	// handler for incoming messages
 	handler := echo.New()
 	handler.Validator = v
 	v1.LoggingMiddleware(handler, cfg.Log.Output)
+	v1.ClientIPMiddleware(handler, resolver)
 	v1.NewRouter(handler, services)
 
 	httpServer := httpserver.NewServer(handler, httpserver.Port(cfg.HTTP.Port))
@@ -74,10 +228,174 @@ func Run() {
 	if err = httpServer.Shutdown(); err != nil {
 		log.Errorf("/app/run http server shutdown error: %s", err)
 	}
+	// письма, поставленные в outbox последними запросами, досылаются до остановки
+	if err = services.Outbox.Shutdown(); err != nil {
+		log.Errorf("/app/run outbox dispatcher shutdown error: %s", err)
+	}
 
 	log.Infof("App shutdown with exit code 0")
 }
 
+// Активный ключ: для HS* алгоритмов секрет JWT_SIGN_KEY, для остальных приватный ключ из PEM файла JWT_KEY_FILE.
+// Ключи из JWT_VERIFY_KEYS используются только для проверки подписи ранее выпущенных токенов
+func loadKeyRing(cfg config.JWT) (*signkey.KeyRing, error) {
+	var (
+		active *signkey.Key
+		err    error
+	)
+	if strings.HasPrefix(cfg.SignMethod, "HS") {
+		active, err = signkey.NewHMAC(cfg.SignMethod, cfg.KeyId, cfg.SignKey)
+	} else {
+		active, err = signkey.Load(cfg.SignMethod, cfg.KeyId, cfg.KeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("jwt key file %s does not contain a private key", cfg.KeyFile)
+	}
+
+	verify := make([]*signkey.Key, 0, len(cfg.VerifyKeys))
+	for _, spec := range cfg.VerifyKeys {
+		k, err := signkey.LoadSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		verify = append(verify, k)
+	}
+	return signkey.NewKeyRing(active, verify...)
+}
+
+// SMTP_TRANSPORT: smtp, maildir (файлы в каталоге SMTP_MAILDIR), stdout или memory (письма только в памяти),
+// чтобы при разработке и в CI не нужен был настоящий почтовый ящик
+func newMailer(cfg config.SMTP) (smtp.Smtp, error) {
+	switch cfg.Transport {
+	case "smtp":
+		return smtp.NewSmtp(
+			smtp.Host(cfg.Host),
+			smtp.Port(cfg.Port),
+			smtp.TLS(smtp.TLSMode(cfg.TLS)),
+			smtp.From(cfg.From),
+			smtp.Auth(smtp.AuthMethod(cfg.Auth), cfg.Login, cfg.Password),
+			smtp.Timeout(cfg.Timeout),
+		)
+	case "maildir":
+		return smtp.NewMaildir(cfg.Maildir)
+	case "stdout":
+		return smtp.NewWriter(os.Stdout), nil
+	case "memory":
+		return smtp.NewRecorder(), nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q", cfg.Transport)
+}
+
+// MAIL_TEMPLATES_DIR: файлы <locale>/<name>.txt|html и layout.html заменяют встроенные шаблоны с тем же путем
+func loadMail(cfg config.Mail, smtpCfg config.SMTP) (service.MailConfig, error) {
+	from := cmp.Or(cfg.From, smtpCfg.From, smtpCfg.Login, "noreply@localhost")
+	if _, err := netmail.ParseAddress(from); err != nil {
+		return service.MailConfig{}, fmt.Errorf("invalid MAIL_FROM %q: %w", from, err)
+	}
+	templates, err := mail.LoadTemplates(cfg.TemplatesDir, cfg.DefaultLocale)
+	if err != nil {
+		return service.MailConfig{}, err
+	}
+	return service.MailConfig{
+		Templates: templates,
+		From:      from,
+	}, nil
+}
+
+// IP_CHANGE_POLICY: allow, allow-and-notify, deny-and-notify или subnet
+func loadIPChange(cfg config.IPChange) (service.IPChangeConfig, error) {
+	policy := service.IPChangePolicy(cfg.Policy)
+	switch policy {
+	case service.IPChangeAllow, service.IPChangeAllowNotify, service.IPChangeDenyNotify, service.IPChangeSubnet:
+	default:
+		return service.IPChangeConfig{}, fmt.Errorf("unknown ip change policy %q", cfg.Policy)
+	}
+	if cfg.SubnetV4 < 0 || cfg.SubnetV4 > 32 || cfg.SubnetV6 < 0 || cfg.SubnetV6 > 128 {
+		return service.IPChangeConfig{}, errors.New("IP_CHANGE_SUBNET_V4 must be in 0..32 and IP_CHANGE_SUBNET_V6 in 0..128")
+	}
+	return service.IPChangeConfig{
+		Policy:     policy,
+		SubnetV4:   cfg.SubnetV4,
+		SubnetV6:   cfg.SubnetV6,
+		KnownIPs:   cfg.KnownIPs,
+		KnownIPTTL: cfg.KnownIPTTL,
+	}, nil
+}
+
+// BREACH_CHECK_MODE: off, warn или reject. Фильтр Блума загружается в память целиком и проверяется быстрее,
+// файлы диапазонов HIBP читаются с диска на каждую проверку, зато не дают ложных срабатываний
+func loadBreachCheck(cfg config.BreachCheck) (service.BreachCheckConfig, error) {
+	var c service.BreachCheckConfig
+	switch cfg.Mode {
+	case "off":
+		return c, nil
+	case "warn":
+	case "reject":
+		c.Reject = true
+	default:
+		return c, fmt.Errorf("unknown breach check mode %q", cfg.Mode)
+	}
+
+	var err error
+	switch {
+	case cfg.BloomFile != "":
+		c.Checker, err = breach.LoadBloom(cfg.BloomFile)
+	case cfg.RangesDir != "":
+		c.Checker, err = breach.NewRanges(cfg.RangesDir, cfg.MinCount)
+	default:
+		err = errors.New("BREACH_BLOOM_FILE or BREACH_RANGES_DIR is required")
+	}
+	return c, err
+}
+
+// MFA_ENCRYPTION_KEY 32 байта в base64. Без ключа подключить второй фактор нельзя
+func loadMFACipher(key string) (*aesgcm.Cipher, error) {
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("MFA_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+	}
+	return aesgcm.New(raw)
+}
+
+// WEBAUTHN_RP_ID домен сервиса (без схемы и порта), без него ключи (passkeys) отключены.
+// WEBAUTHN_ORIGINS полные адреса фронтенда, с которых разрешены церемонии
+func newRelyingParty(cfg config.WebAuthn) (*webauthn.WebAuthn, error) {
+	if cfg.RPID == "" {
+		return nil, nil
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.Origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.ChallengeTTL, TimeoutUVD: cfg.ChallengeTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.ChallengeTTL, TimeoutUVD: cfg.ChallengeTTL},
+		},
+	})
+}
+
+// HASHER_PEPPERS задается как version:secret,version:secret
+func parsePeppers(raw map[string]string) (map[int]string, error) {
+	peppers := make(map[int]string, len(raw))
+	for k, secret := range raw {
+		version, err := strconv.Atoi(k)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid pepper version %q, expected positive integer", k)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("empty pepper for version %d", version)
+		}
+		peppers[version] = secret
+	}
+	return peppers, nil
+}
+
 // loading environment params from .env
 func init() {
 	if _, ok := os.LookupEnv("HTTP_PORT"); !ok {
@@ -0,0 +1,11 @@
+package dbmodel
+
+import "time"
+
+// KnownIP адрес, с которого пользователь уже входил в аккаунт
+type KnownIP struct {
+	UserId    string    `db:"user_id"`
+	IP        string    `db:"ip"`
+	FirstSeen time.Time `db:"first_seen"`
+	LastSeen  time.Time `db:"last_seen"`
+}
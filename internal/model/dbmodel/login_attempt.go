@@ -0,0 +1,11 @@
+package dbmodel
+
+import "time"
+
+// LoginAttempt счетчик неудачных попыток входа. Key - "user:<user_id>" или "ip:<addr>"
+type LoginAttempt struct {
+	Key         string     `db:"key"`
+	Failures    int        `db:"failures"`
+	LastFailure time.Time  `db:"last_failure"`
+	LockedUntil *time.Time `db:"locked_until"`
+}
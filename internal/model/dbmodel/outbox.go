@@ -0,0 +1,16 @@
+package dbmodel
+
+import "time"
+
+// OutboxMessage письмо, ожидающее отправки. Сообщение с DeadAt исчерпало попытки и больше не отправляется
+type OutboxMessage struct {
+	Id            int64      `db:"id"`
+	Kind          string     `db:"kind"`
+	Recipient     string     `db:"recipient"`
+	Message       string     `db:"message"`
+	Attempts      int        `db:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+	LastError     *string    `db:"last_error"`
+	CreatedAt     time.Time  `db:"created_at"`
+	DeadAt        *time.Time `db:"dead_at"`
+}
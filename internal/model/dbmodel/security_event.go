@@ -0,0 +1,13 @@
+package dbmodel
+
+import "time"
+
+type SecurityEvent struct {
+	Id        int       `db:"id"`
+	UserId    string    `db:"user_id"`
+	SessionId string    `db:"session_id"`
+	Type      string    `db:"type"`
+	IP        string    `db:"ip"`
+	Details   string    `db:"details"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,18 @@
+package dbmodel
+
+import "time"
+
+type Session struct {
+	Id           int        `db:"id"`
+	SessionId    string     `db:"session_id"`
+	UserId       string     `db:"user_id"`
+	RefreshToken string     `db:"refresh_token"`
+	Generation   int        `db:"generation"`
+	Device       string     `db:"device"`
+	IP           string     `db:"ip"`
+	UserAgent    string     `db:"user_agent"`
+	CreatedAt    time.Time  `db:"created_at"`
+	RefreshedAt  time.Time  `db:"refreshed_at"`
+	ExpiresAt    time.Time  `db:"expires_at"`
+	RevokedAt    *time.Time `db:"revoked_at"`
+}
@@ -0,0 +1,13 @@
+package dbmodel
+
+import "time"
+
+// TOTP секрет второго фактора пользователя. Secret хранится зашифрованным, до подтверждения первым кодом
+// ConfirmedAt пустой и второй фактор не действует
+type TOTP struct {
+	UserId       string     `db:"user_id"`
+	Secret       string     `db:"secret"`
+	ConfirmedAt  *time.Time `db:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
@@ -1,9 +1,13 @@
 package dbmodel
 
 type User struct {
-	Id           int    `db:"id"`
-	UserId       string `db:"user_id"`
-	Email        string `db:"email"`
-	Password     string `db:"password"`
-	RefreshToken string `db:"refresh_token"`
+	Id       int    `db:"id"`
+	UserId   string `db:"user_id"`
+	Email    string `db:"email"`
+	Username string `db:"username"`
+	Password string `db:"password"`
+	// EmailVerified почта подтверждена переходом по ссылке из письма
+	EmailVerified bool `db:"email_verified"`
+	// Locale язык писем, например "ru" или "pt-br"; пусто - язык по умолчанию
+	Locale string `db:"locale"`
 }
@@ -0,0 +1,15 @@
+package dbmodel
+
+import "time"
+
+// UserToken одноразовый токен, отправляемый пользователю на почту. В бд хранится только хэш
+type UserToken struct {
+	Id        int        `db:"id"`
+	UserId    string     `db:"user_id"`
+	Purpose   string     `db:"purpose"`
+	TokenHash string     `db:"token_hash"`
+	Payload   string     `db:"payload"` // данные, подтверждаемые токеном, например новый email
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
@@ -0,0 +1,30 @@
+package dbmodel
+
+import "time"
+
+type WebAuthnCredential struct {
+	Id              int        `db:"id"`
+	UserId          string     `db:"user_id"`
+	CredentialId    []byte     `db:"credential_id"`
+	PublicKey       []byte     `db:"public_key"`
+	AttestationType string     `db:"attestation_type"`
+	AAGUID          []byte     `db:"aaguid"`
+	SignCount       int64      `db:"sign_count"`
+	Transports      string     `db:"transports"` // через запятую
+	BackupEligible  bool       `db:"backup_eligible"`
+	BackupState     bool       `db:"backup_state"`
+	Name            string     `db:"name"`
+	SecondFactor    bool       `db:"second_factor"` // ключ запрашивается вторым фактором при входе по паролю
+	CreatedAt       time.Time  `db:"created_at"`
+	LastUsedAt      *time.Time `db:"last_used_at"`
+}
+
+// WebAuthnChallenge состояние незавершенной регистрации или входа, SessionData - json сессии webauthn
+type WebAuthnChallenge struct {
+	Id          string    `db:"id"`
+	UserId      string    `db:"user_id"`
+	Purpose     string    `db:"purpose"`
+	SessionData string    `db:"session_data"`
+	ExpiresAt   time.Time `db:"expires_at"`
+	CreatedAt   time.Time `db:"created_at"`
+}
@@ -0,0 +1,55 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type KnownIPRepo struct {
+	*postgres.Postgres
+}
+
+func NewKnownIPRepo(pg *postgres.Postgres) *KnownIPRepo {
+	return &KnownIPRepo{pg}
+}
+
+// Remember добавляет адрес пользователя или обновляет время последнего входа с него
+func (r *KnownIPRepo) Remember(ctx context.Context, userId, ip string) error {
+	sql, args, _ := r.Builder.
+		Insert("known_ips").
+		Columns("user_id", "ip").
+		Values(userId, ip).
+		Suffix("on conflict (user_id, ip) do update set last_seen = now()").
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return pgerrs.ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// IsKnown проверяет, входил ли пользователь с адреса после since
+func (r *KnownIPRepo) IsKnown(ctx context.Context, userId, ip string, since time.Time) (bool, error) {
+	sql, args, _ := r.Builder.
+		Select("1").
+		Prefix("select exists (").
+		From("known_ips").
+		Where("user_id = ? and ip = ? and last_seen > ?", userId, ip, since).
+		Suffix(")").
+		ToSql()
+
+	var known bool
+	if err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(&known); err != nil {
+		return false, err
+	}
+	return known, nil
+}
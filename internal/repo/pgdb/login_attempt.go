@@ -0,0 +1,96 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type LoginAttemptRepo struct {
+	*postgres.Postgres
+}
+
+func NewLoginAttemptRepo(pg *postgres.Postgres) *LoginAttemptRepo {
+	return &LoginAttemptRepo{pg}
+}
+
+func (r *LoginAttemptRepo) Find(ctx context.Context, key string) (dbmodel.LoginAttempt, error) {
+	sql, args, _ := r.Builder.
+		Select("key, failures, last_failure, locked_until").
+		From("login_attempts").
+		Where("key = ?", key).
+		ToSql()
+
+	var a dbmodel.LoginAttempt
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailure,
+		&a.LockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.LoginAttempt{}, pgerrs.ErrNotFound
+		}
+		return dbmodel.LoginAttempt{}, err
+	}
+	return a, nil
+}
+
+// RegisterFailure атомарно увеличивает счетчик неудачных попыток. Если с прошлой неудачи прошло больше window,
+// счет начинается заново. Атомарность важна, так как попытки могут приходить на разные реплики api
+func (r *LoginAttemptRepo) RegisterFailure(ctx context.Context, key string, window time.Duration) (dbmodel.LoginAttempt, error) {
+	now := time.Now()
+	sql, args, _ := r.Builder.
+		Insert("login_attempts").
+		Columns("key", "failures", "last_failure").
+		Values(key, 1, now).
+		Suffix("on conflict (key) do update set "+
+			"failures = case when login_attempts.last_failure < ? then 1 else login_attempts.failures + 1 end, "+
+			"last_failure = excluded.last_failure "+
+			"returning key, failures, last_failure, locked_until", now.Add(-window)).
+		ToSql()
+
+	var a dbmodel.LoginAttempt
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailure,
+		&a.LockedUntil,
+	)
+	if err != nil {
+		return dbmodel.LoginAttempt{}, err
+	}
+	return a, nil
+}
+
+// Lock блокирует ключ до until и обнуляет счетчик, после блокировки задержки начинаются заново.
+// Уже заблокированный ключ не меняется, false означает, что его заблокировал параллельный запрос
+func (r *LoginAttemptRepo) Lock(ctx context.Context, key string, until time.Time) (bool, error) {
+	sql, args, _ := r.Builder.
+		Update("login_attempts").
+		Set("locked_until", until).
+		Set("failures", 0).
+		Where("key = ? and (locked_until is null or locked_until < ?)", key, time.Now()).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *LoginAttemptRepo) Reset(ctx context.Context, key string) error {
+	sql, args, _ := r.Builder.
+		Delete("login_attempts").
+		Where("key = ?", key).
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
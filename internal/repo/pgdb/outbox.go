@@ -0,0 +1,128 @@
+package pgdb
+
+import (
+	"context"
+	"github.com/Masterminds/squirrel"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type OutboxRepo struct {
+	*postgres.Postgres
+}
+
+func NewOutboxRepo(pg *postgres.Postgres) *OutboxRepo {
+	return &OutboxRepo{pg}
+}
+
+func (r *OutboxRepo) Enqueue(ctx context.Context, m dbmodel.OutboxMessage) error {
+	sql, args, _ := r.Builder.
+		Insert("outbox").
+		Columns("kind", "recipient", "message").
+		Values(m.Kind, m.Recipient, m.Message).
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
+
+// Claim забирает до limit готовых к отправке сообщений и откладывает их следующую попытку на lease.
+// Если отправитель упадет, не отчитавшись, сообщения снова станут доступны после lease.
+// skip locked позволяет нескольким репликам разбирать outbox параллельно без повторной отправки
+func (r *OutboxRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]dbmodel.OutboxMessage, error) {
+	now := time.Now()
+	sql, args, _ := r.Builder.
+		Update("outbox").
+		Set("next_attempt_at", now.Add(lease)).
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Where("id in (select id from outbox where dead_at is null and next_attempt_at <= ? "+
+			"order by next_attempt_at limit ? for update skip locked)", now, limit).
+		Suffix("returning id, kind, recipient, message, attempts, next_attempt_at, last_error, created_at, dead_at").
+		ToSql()
+
+	rows, err := r.Conn(ctx).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []dbmodel.OutboxMessage
+	for rows.Next() {
+		var m dbmodel.OutboxMessage
+		err = rows.Scan(
+			&m.Id,
+			&m.Kind,
+			&m.Recipient,
+			&m.Message,
+			&m.Attempts,
+			&m.NextAttemptAt,
+			&m.LastError,
+			&m.CreatedAt,
+			&m.DeadAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Delete удаляет отправленное сообщение
+func (r *OutboxRepo) Delete(ctx context.Context, id int64) error {
+	sql, args, _ := r.Builder.
+		Delete("outbox").
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+// Retry назначает следующую попытку отправки
+func (r *OutboxRepo) Retry(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	sql, args, _ := r.Builder.
+		Update("outbox").
+		Set("next_attempt_at", nextAttemptAt).
+		Set("last_error", lastError).
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+// Bury переносит сообщение в dead letter: для разбора остаются тип, получатель и ошибка, но больше оно не отправляется.
+// Текст письма стирается, так как в нем могут быть действующие токены сброса пароля, подтверждения и входа
+func (r *OutboxRepo) Bury(ctx context.Context, id int64, lastError string) error {
+	sql, args, _ := r.Builder.
+		Update("outbox").
+		Set("dead_at", time.Now()).
+		Set("last_error", lastError).
+		Set("message", "").
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package pgdb
+
+import (
+	"context"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type RecoveryCodeRepo struct {
+	*postgres.Postgres
+}
+
+func NewRecoveryCodeRepo(pg *postgres.Postgres) *RecoveryCodeRepo {
+	return &RecoveryCodeRepo{pg}
+}
+
+// Replace удаляет прежние коды пользователя и сохраняет новые в одной транзакции. Внутри WithinTransaction
+// вызывающего выполняется в его транзакции
+func (r *RecoveryCodeRepo) Replace(ctx context.Context, userId string, codeHashes []string) error {
+	return r.WithinTransaction(ctx, func(ctx context.Context) error {
+		sql, args, _ := r.Builder.
+			Delete("recovery_codes").
+			Where("user_id = ?", userId).
+			ToSql()
+		if _, err := r.Conn(ctx).Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+
+		insert := r.Builder.
+			Insert("recovery_codes").
+			Columns("user_id", "code_hash")
+		for _, hash := range codeHashes {
+			insert = insert.Values(userId, hash)
+		}
+		sql, args, _ = insert.ToSql()
+		_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+		return err
+	})
+}
+
+// Use помечает код использованным. Неизвестный или уже использованный код дает pgerrs.ErrNotFound
+func (r *RecoveryCodeRepo) Use(ctx context.Context, userId, codeHash string) error {
+	sql, args, _ := r.Builder.
+		Update("recovery_codes").
+		Set("used_at", time.Now()).
+		Where("user_id = ? and code_hash = ? and used_at is null", userId, codeHash).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *RecoveryCodeRepo) DeleteAll(ctx context.Context, userId string) error {
+	sql, args, _ := r.Builder.
+		Delete("recovery_codes").
+		Where("user_id = ?", userId).
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
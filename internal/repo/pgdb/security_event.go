@@ -0,0 +1,25 @@
+package pgdb
+
+import (
+	"context"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/pkg/postgres"
+)
+
+type SecurityEventRepo struct {
+	*postgres.Postgres
+}
+
+func NewSecurityEventRepo(pg *postgres.Postgres) *SecurityEventRepo {
+	return &SecurityEventRepo{pg}
+}
+
+func (r *SecurityEventRepo) Create(ctx context.Context, e dbmodel.SecurityEvent) error {
+	sql, args, _ := r.Builder.
+		Insert("security_events").
+		Columns("user_id", "session_id", "type", "ip", "details").
+		Values(e.UserId, e.SessionId, e.Type, e.IP, e.Details).
+		ToSql()
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
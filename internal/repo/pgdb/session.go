@@ -0,0 +1,173 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type SessionRepo struct {
+	*postgres.Postgres
+}
+
+func NewSessionRepo(pg *postgres.Postgres) *SessionRepo {
+	return &SessionRepo{pg}
+}
+
+func (r *SessionRepo) Create(ctx context.Context, s dbmodel.Session) error {
+	sql, args, _ := r.Builder.
+		Insert("sessions").
+		Columns("session_id", "user_id", "refresh_token", "device", "ip", "user_agent", "expires_at").
+		Values(s.SessionId, s.UserId, s.RefreshToken, s.Device, s.IP, s.UserAgent, s.ExpiresAt).
+		ToSql()
+	if _, err := r.Conn(ctx).Exec(ctx, sql, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			switch pgErr.Code {
+			case "23505":
+				return pgerrs.ErrAlreadyExist
+			case "23503": // нет пользователя, которому принадлежит сессия
+				return pgerrs.ErrNotFound
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *SessionRepo) FindById(ctx context.Context, sessionId string) (dbmodel.Session, error) {
+	sql, args, _ := r.Builder.
+		Select("id, session_id, user_id, refresh_token, generation, device, ip, user_agent, created_at, refreshed_at, expires_at, revoked_at").
+		From("sessions").
+		Where("session_id = ?", sessionId).
+		ToSql()
+
+	var s dbmodel.Session
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&s.Id,
+		&s.SessionId,
+		&s.UserId,
+		&s.RefreshToken,
+		&s.Generation,
+		&s.Device,
+		&s.IP,
+		&s.UserAgent,
+		&s.CreatedAt,
+		&s.RefreshedAt,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.Session{}, pgerrs.ErrNotFound
+		}
+		return dbmodel.Session{}, err
+	}
+	return s, nil
+}
+
+func (r *SessionRepo) FindActiveByUser(ctx context.Context, userId string) ([]dbmodel.Session, error) {
+	sql, args, _ := r.Builder.
+		Select("id, session_id, user_id, refresh_token, generation, device, ip, user_agent, created_at, refreshed_at, expires_at, revoked_at").
+		From("sessions").
+		Where("user_id = ? and revoked_at is null and expires_at > now()", userId).
+		OrderBy("refreshed_at desc").
+		ToSql()
+
+	rows, err := r.Conn(ctx).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []dbmodel.Session
+	for rows.Next() {
+		var s dbmodel.Session
+		err = rows.Scan(
+			&s.Id,
+			&s.SessionId,
+			&s.UserId,
+			&s.RefreshToken,
+			&s.Generation,
+			&s.Device,
+			&s.IP,
+			&s.UserAgent,
+			&s.CreatedAt,
+			&s.RefreshedAt,
+			&s.ExpiresAt,
+			&s.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Rotate заменяет refresh токен сессии и увеличивает его поколение. Обновление выполнится только если
+// текущее поколение совпадает с generation, поэтому из двух одновременных рефрешей одним токеном пройдет только один
+func (r *SessionRepo) Rotate(ctx context.Context, sessionId string, generation int, token string, expiresAt time.Time) error {
+	sql, args, _ := r.Builder.
+		Update("sessions").
+		Set("refresh_token", token).
+		Set("generation", generation+1).
+		Set("refreshed_at", time.Now()).
+		Set("expires_at", expiresAt).
+		Where("session_id = ? and generation = ? and revoked_at is null", sessionId, generation).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *SessionRepo) Revoke(ctx context.Context, sessionId string) error {
+	sql, args, _ := r.Builder.
+		Update("sessions").
+		Set("revoked_at", time.Now()).
+		Where("session_id = ? and revoked_at is null", sessionId).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *SessionRepo) RevokeAllByUser(ctx context.Context, userId string) error {
+	sql, args, _ := r.Builder.
+		Update("sessions").
+		Set("revoked_at", time.Now()).
+		Where("user_id = ? and revoked_at is null", userId).
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
+
+// RevokeOthers отзывает все сессии пользователя, кроме текущей
+func (r *SessionRepo) RevokeOthers(ctx context.Context, userId, sessionId string) error {
+	sql, args, _ := r.Builder.
+		Update("sessions").
+		Set("revoked_at", time.Now()).
+		Where("user_id = ? and session_id <> ? and revoked_at is null", userId, sessionId).
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
@@ -0,0 +1,114 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type TOTPRepo struct {
+	*postgres.Postgres
+}
+
+func NewTOTPRepo(pg *postgres.Postgres) *TOTPRepo {
+	return &TOTPRepo{pg}
+}
+
+// Save создает или заменяет неподтвержденный секрет. Подтвержденный секрет не перезаписывается (pgerrs.ErrAlreadyExist)
+func (r *TOTPRepo) Save(ctx context.Context, t dbmodel.TOTP) error {
+	sql, args, _ := r.Builder.
+		Insert("user_totp").
+		Columns("user_id", "secret").
+		Values(t.UserId, t.Secret).
+		Suffix("on conflict (user_id) do update set secret = excluded.secret, last_used_step = 0, created_at = now() " +
+			"where user_totp.confirmed_at is null").
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return pgerrs.ErrNotFound
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrAlreadyExist
+	}
+	return nil
+}
+
+func (r *TOTPRepo) Find(ctx context.Context, userId string) (dbmodel.TOTP, error) {
+	sql, args, _ := r.Builder.
+		Select("user_id, secret, confirmed_at, last_used_step, created_at").
+		From("user_totp").
+		Where("user_id = ?", userId).
+		ToSql()
+
+	var t dbmodel.TOTP
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&t.UserId,
+		&t.Secret,
+		&t.ConfirmedAt,
+		&t.LastUsedStep,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.TOTP{}, pgerrs.ErrNotFound
+		}
+		return dbmodel.TOTP{}, err
+	}
+	return t, nil
+}
+
+func (r *TOTPRepo) Confirm(ctx context.Context, userId string, step int64) error {
+	sql, args, _ := r.Builder.
+		Update("user_totp").
+		Set("confirmed_at", time.Now()).
+		Set("last_used_step", step).
+		Where("user_id = ? and confirmed_at is null", userId).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+// UseStep запоминает шаг использованного кода. Шаг не новее последнего (повтор кода) дает pgerrs.ErrNotFound
+func (r *TOTPRepo) UseStep(ctx context.Context, userId string, step int64) error {
+	sql, args, _ := r.Builder.
+		Update("user_totp").
+		Set("last_used_step", step).
+		Where("user_id = ? and last_used_step < ?", userId, step).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *TOTPRepo) Delete(ctx context.Context, userId string) error {
+	sql, args, _ := r.Builder.
+		Delete("user_totp").
+		Where("user_id = ?", userId).
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
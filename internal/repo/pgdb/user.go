@@ -3,6 +3,7 @@ package pgdb
 import (
 	"context"
 	"errors"
+	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"test_auth/internal/model/dbmodel"
@@ -21,10 +22,10 @@ func NewUserRepo(pg *postgres.Postgres) *UserRepo {
 func (r *UserRepo) Create(ctx context.Context, u dbmodel.User) error {
 	sql, args, _ := r.Builder.
 		Insert("users").
-		Columns("user_id", "email", "password").
-		Values(u.UserId, u.Email, u.Password).
+		Columns("user_id", "email", "username", "password", "locale").
+		Values(u.UserId, u.Email, squirrel.Expr("nullif(?, '')", u.Username), u.Password, u.Locale).
 		ToSql()
-	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+	if _, err := r.Conn(ctx).Exec(ctx, sql, args...); err != nil {
 		var pgErr *pgconn.PgError
 		if ok := errors.As(err, &pgErr); ok {
 			if pgErr.Code == "23505" {
@@ -37,18 +38,34 @@ func (r *UserRepo) Create(ctx context.Context, u dbmodel.User) error {
 }
 
 func (r *UserRepo) FindById(ctx context.Context, userId string) (dbmodel.User, error) {
+	return r.findBy(ctx, squirrel.Eq{"user_id": userId})
+}
+
+// FindByEmail ищет пользователя без учета регистра, запрос использует индекс по lower(email)
+func (r *UserRepo) FindByEmail(ctx context.Context, email string) (dbmodel.User, error) {
+	return r.findBy(ctx, squirrel.Expr("lower(email) = lower(?)", email))
+}
+
+func (r *UserRepo) FindByUsername(ctx context.Context, username string) (dbmodel.User, error) {
+	return r.findBy(ctx, squirrel.Expr("lower(username) = lower(?)", username))
+}
+
+func (r *UserRepo) findBy(ctx context.Context, pred squirrel.Sqlizer) (dbmodel.User, error) {
 	sql, args, _ := r.Builder.
-		Select("id, email, password, refresh_token").
+		Select("id, user_id, email, coalesce(username, ''), password, email_verified, locale").
 		From("users").
-		Where("user_id = ?", userId).
+		Where(pred).
 		ToSql()
 
 	var u dbmodel.User
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
 		&u.Id,
+		&u.UserId,
 		&u.Email,
+		&u.Username,
 		&u.Password,
-		&u.RefreshToken,
+		&u.EmailVerified,
+		&u.Locale,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -59,18 +76,78 @@ func (r *UserRepo) FindById(ctx context.Context, userId string) (dbmodel.User, e
 	return u, nil
 }
 
-func (r *UserRepo) UpdateToken(ctx context.Context, userId, token string) error {
+func (r *UserRepo) UpdatePassword(ctx context.Context, userId, password string) error {
 	sql, args, _ := r.Builder.
 		Update("users").
-		Set("refresh_token", token).
+		Set("password", password).
 		Where("user_id = ?", userId).
 		ToSql()
 
-	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return pgerrs.ErrNotFound
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) SetEmailVerified(ctx context.Context, userId string) error {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("email_verified", true).
+		Where("user_id = ?", userId).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateEmail меняет почту на подтвержденную пользователем
+func (r *UserRepo) UpdateEmail(ctx context.Context, userId, email string) error {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("email", email).
+		Set("email_verified", true).
+		Where("user_id = ?", userId).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "23505" {
+				return pgerrs.ErrAlreadyExist
+			}
 		}
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdateLocale(ctx context.Context, userId, locale string) error {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("locale", locale).
+		Where("user_id = ?", userId).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
 	return nil
 }
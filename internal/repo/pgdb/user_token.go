@@ -0,0 +1,127 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type UserTokenRepo struct {
+	*postgres.Postgres
+}
+
+func NewUserTokenRepo(pg *postgres.Postgres) *UserTokenRepo {
+	return &UserTokenRepo{pg}
+}
+
+func (r *UserTokenRepo) Create(ctx context.Context, t dbmodel.UserToken) error {
+	sql, args, _ := r.Builder.
+		Insert("user_tokens").
+		Columns("user_id", "purpose", "token_hash", "payload", "expires_at").
+		Values(t.UserId, t.Purpose, t.TokenHash, t.Payload, t.ExpiresAt).
+		ToSql()
+	if _, err := r.Conn(ctx).Exec(ctx, sql, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "23505" {
+				return pgerrs.ErrAlreadyExist
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Find возвращает действующий токен, не помечая его использованным
+func (r *UserTokenRepo) Find(ctx context.Context, purpose, tokenHash string) (dbmodel.UserToken, error) {
+	sql, args, _ := r.Builder.
+		Select("id, user_id, purpose, token_hash, payload, expires_at, used_at, created_at").
+		From("user_tokens").
+		Where("purpose = ? and token_hash = ? and used_at is null and expires_at > now()", purpose, tokenHash).
+		ToSql()
+
+	var t dbmodel.UserToken
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&t.Id,
+		&t.UserId,
+		&t.Purpose,
+		&t.TokenHash,
+		&t.Payload,
+		&t.ExpiresAt,
+		&t.UsedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.UserToken{}, pgerrs.ErrNotFound
+		}
+		return dbmodel.UserToken{}, err
+	}
+	return t, nil
+}
+
+// Use помечает действующий токен использованным и возвращает его. Повторное использование,
+// как и истекший токен, дает pgerrs.ErrNotFound
+func (r *UserTokenRepo) Use(ctx context.Context, purpose, tokenHash string) (dbmodel.UserToken, error) {
+	sql, args, _ := r.Builder.
+		Update("user_tokens").
+		Set("used_at", time.Now()).
+		Where("purpose = ? and token_hash = ? and used_at is null and expires_at > now()", purpose, tokenHash).
+		Suffix("returning id, user_id, purpose, token_hash, payload, expires_at, used_at, created_at").
+		ToSql()
+
+	var t dbmodel.UserToken
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&t.Id,
+		&t.UserId,
+		&t.Purpose,
+		&t.TokenHash,
+		&t.Payload,
+		&t.ExpiresAt,
+		&t.UsedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.UserToken{}, pgerrs.ErrNotFound
+		}
+		return dbmodel.UserToken{}, err
+	}
+	return t, nil
+}
+
+// LastCreatedAt время создания последнего токена пользователя с указанным назначением
+func (r *UserTokenRepo) LastCreatedAt(ctx context.Context, userId, purpose string) (time.Time, error) {
+	sql, args, _ := r.Builder.
+		Select("created_at").
+		From("user_tokens").
+		Where("user_id = ? and purpose = ?", userId, purpose).
+		OrderBy("created_at desc").
+		Limit(1).
+		ToSql()
+
+	var createdAt time.Time
+	if err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(&createdAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, pgerrs.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return createdAt, nil
+}
+
+// RevokeAll делает недействительными все неиспользованные токены пользователя с указанным назначением
+func (r *UserTokenRepo) RevokeAll(ctx context.Context, userId, purpose string) error {
+	sql, args, _ := r.Builder.
+		Update("user_tokens").
+		Set("used_at", time.Now()).
+		Where("user_id = ? and purpose = ? and used_at is null", userId, purpose).
+		ToSql()
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
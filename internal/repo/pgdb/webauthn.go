@@ -0,0 +1,170 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/postgres"
+	"time"
+)
+
+type WebAuthnRepo struct {
+	*postgres.Postgres
+}
+
+func NewWebAuthnRepo(pg *postgres.Postgres) *WebAuthnRepo {
+	return &WebAuthnRepo{pg}
+}
+
+func (r *WebAuthnRepo) CreateCredential(ctx context.Context, c dbmodel.WebAuthnCredential) error {
+	sql, args, _ := r.Builder.
+		Insert("webauthn_credentials").
+		Columns("user_id", "credential_id", "public_key", "attestation_type", "aaguid", "sign_count",
+			"transports", "backup_eligible", "backup_state", "name", "second_factor").
+		Values(c.UserId, c.CredentialId, c.PublicKey, c.AttestationType, c.AAGUID, c.SignCount,
+			c.Transports, c.BackupEligible, c.BackupState, c.Name, c.SecondFactor).
+		ToSql()
+	if _, err := r.Conn(ctx).Exec(ctx, sql, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			switch pgErr.Code {
+			case "23505":
+				return pgerrs.ErrAlreadyExist
+			case "23503":
+				return pgerrs.ErrNotFound
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *WebAuthnRepo) FindCredentialsByUser(ctx context.Context, userId string) ([]dbmodel.WebAuthnCredential, error) {
+	sql, args, _ := r.Builder.
+		Select("id, user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, "+
+			"backup_eligible, backup_state, name, second_factor, created_at, last_used_at").
+		From("webauthn_credentials").
+		Where("user_id = ?", userId).
+		OrderBy("created_at").
+		ToSql()
+
+	rows, err := r.Conn(ctx).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []dbmodel.WebAuthnCredential
+	for rows.Next() {
+		var c dbmodel.WebAuthnCredential
+		err = rows.Scan(
+			&c.Id,
+			&c.UserId,
+			&c.CredentialId,
+			&c.PublicKey,
+			&c.AttestationType,
+			&c.AAGUID,
+			&c.SignCount,
+			&c.Transports,
+			&c.BackupEligible,
+			&c.BackupState,
+			&c.Name,
+			&c.SecondFactor,
+			&c.CreatedAt,
+			&c.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdateCredentialUsage сохраняет счетчик подписей и флаг резервной копии после успешного входа
+func (r *WebAuthnRepo) UpdateCredentialUsage(ctx context.Context, credentialId []byte, signCount int64, backupState bool) error {
+	sql, args, _ := r.Builder.
+		Update("webauthn_credentials").
+		Set("sign_count", signCount).
+		Set("backup_state", backupState).
+		Set("last_used_at", time.Now()).
+		Where("credential_id = ?", credentialId).
+		ToSql()
+
+	tag, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteCredential удаляет ключ пользователя и возвращает его название для уведомления владельца
+func (r *WebAuthnRepo) DeleteCredential(ctx context.Context, userId string, id int) (string, error) {
+	sql, args, _ := r.Builder.
+		Delete("webauthn_credentials").
+		Where("user_id = ? and id = ?", userId, id).
+		Suffix("returning name").
+		ToSql()
+
+	var name string
+	if err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(&name); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", pgerrs.ErrNotFound
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+func (r *WebAuthnRepo) CreateChallenge(ctx context.Context, c dbmodel.WebAuthnChallenge) error {
+	sql, args, _ := r.Builder.
+		Insert("webauthn_challenges").
+		Columns("id", "user_id", "purpose", "session_data", "expires_at").
+		Values(c.Id, c.UserId, c.Purpose, c.SessionData, c.ExpiresAt).
+		ToSql()
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
+
+// TakeChallenge удаляет и возвращает действующий challenge, поэтому каждый можно использовать только один раз
+func (r *WebAuthnRepo) TakeChallenge(ctx context.Context, id, purpose string) (dbmodel.WebAuthnChallenge, error) {
+	sql, args, _ := r.Builder.
+		Delete("webauthn_challenges").
+		Where("id = ? and purpose = ? and expires_at > now()", id, purpose).
+		Suffix("returning id, user_id, purpose, session_data, expires_at, created_at").
+		ToSql()
+
+	var c dbmodel.WebAuthnChallenge
+	err := r.Conn(ctx).QueryRow(ctx, sql, args...).Scan(
+		&c.Id,
+		&c.UserId,
+		&c.Purpose,
+		&c.SessionData,
+		&c.ExpiresAt,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.WebAuthnChallenge{}, pgerrs.ErrNotFound
+		}
+		return dbmodel.WebAuthnChallenge{}, err
+	}
+	return c, nil
+}
+
+// DeleteExpiredChallenges удаляет брошенные на середине церемонии challenge
+func (r *WebAuthnRepo) DeleteExpiredChallenges(ctx context.Context) error {
+	sql, args, _ := r.Builder.
+		Delete("webauthn_challenges").
+		Where("expires_at <= now()").
+		ToSql()
+
+	_, err := r.Conn(ctx).Exec(ctx, sql, args...)
+	return err
+}
@@ -5,20 +5,117 @@ import (
 	"test_auth/internal/model/dbmodel"
 	"test_auth/internal/repo/pgdb"
 	"test_auth/pkg/postgres"
+	"time"
 )
 
 type User interface {
 	Create(ctx context.Context, u dbmodel.User) error
 	FindById(ctx context.Context, userId string) (dbmodel.User, error)
-	UpdateToken(ctx context.Context, userId, token string) error
+	FindByEmail(ctx context.Context, email string) (dbmodel.User, error)
+	FindByUsername(ctx context.Context, username string) (dbmodel.User, error)
+	UpdatePassword(ctx context.Context, userId, password string) error
+	SetEmailVerified(ctx context.Context, userId string) error
+	UpdateEmail(ctx context.Context, userId, email string) error
+	UpdateLocale(ctx context.Context, userId, locale string) error
+}
+
+type UserToken interface {
+	Create(ctx context.Context, t dbmodel.UserToken) error
+	Find(ctx context.Context, purpose, tokenHash string) (dbmodel.UserToken, error)
+	Use(ctx context.Context, purpose, tokenHash string) (dbmodel.UserToken, error)
+	LastCreatedAt(ctx context.Context, userId, purpose string) (time.Time, error)
+	RevokeAll(ctx context.Context, userId, purpose string) error
+}
+
+type Session interface {
+	Create(ctx context.Context, s dbmodel.Session) error
+	FindById(ctx context.Context, sessionId string) (dbmodel.Session, error)
+	FindActiveByUser(ctx context.Context, userId string) ([]dbmodel.Session, error)
+	Rotate(ctx context.Context, sessionId string, generation int, token string, expiresAt time.Time) error
+	Revoke(ctx context.Context, sessionId string) error
+	RevokeAllByUser(ctx context.Context, userId string) error
+	RevokeOthers(ctx context.Context, userId, sessionId string) error
+}
+
+type SecurityEvent interface {
+	Create(ctx context.Context, e dbmodel.SecurityEvent) error
+}
+
+// Transactor выполняет fn в транзакции, репозитории внутри fn используют ее через контекст
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type Outbox interface {
+	Enqueue(ctx context.Context, m dbmodel.OutboxMessage) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]dbmodel.OutboxMessage, error)
+	Delete(ctx context.Context, id int64) error
+	Retry(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+	Bury(ctx context.Context, id int64, lastError string) error
+}
+
+type KnownIP interface {
+	Remember(ctx context.Context, userId, ip string) error
+	IsKnown(ctx context.Context, userId, ip string, since time.Time) (bool, error)
+}
+
+type LoginAttempt interface {
+	Find(ctx context.Context, key string) (dbmodel.LoginAttempt, error)
+	RegisterFailure(ctx context.Context, key string, window time.Duration) (dbmodel.LoginAttempt, error)
+	Lock(ctx context.Context, key string, until time.Time) (bool, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type TOTP interface {
+	Save(ctx context.Context, t dbmodel.TOTP) error
+	Find(ctx context.Context, userId string) (dbmodel.TOTP, error)
+	Confirm(ctx context.Context, userId string, step int64) error
+	UseStep(ctx context.Context, userId string, step int64) error
+	Delete(ctx context.Context, userId string) error
+}
+
+type RecoveryCode interface {
+	Replace(ctx context.Context, userId string, codeHashes []string) error
+	Use(ctx context.Context, userId, codeHash string) error
+	DeleteAll(ctx context.Context, userId string) error
+}
+
+type WebAuthn interface {
+	CreateCredential(ctx context.Context, c dbmodel.WebAuthnCredential) error
+	FindCredentialsByUser(ctx context.Context, userId string) ([]dbmodel.WebAuthnCredential, error)
+	UpdateCredentialUsage(ctx context.Context, credentialId []byte, signCount int64, backupState bool) error
+	DeleteCredential(ctx context.Context, userId string, id int) (string, error)
+	CreateChallenge(ctx context.Context, c dbmodel.WebAuthnChallenge) error
+	TakeChallenge(ctx context.Context, id, purpose string) (dbmodel.WebAuthnChallenge, error)
+	DeleteExpiredChallenges(ctx context.Context) error
 }
 
 type Repositories struct {
+	Transactor
 	User
+	UserToken
+	Session
+	SecurityEvent
+	KnownIP
+	LoginAttempt
+	TOTP
+	RecoveryCode
+	WebAuthn
+	Outbox
 }
 
 func NewRepositories(pg *postgres.Postgres) *Repositories {
 	return &Repositories{
-		User: pgdb.NewUserRepo(pg),
+		Transactor:    pg,
+		User:          pgdb.NewUserRepo(pg),
+		UserToken:     pgdb.NewUserTokenRepo(pg),
+		Session:       pgdb.NewSessionRepo(pg),
+		SecurityEvent: pgdb.NewSecurityEventRepo(pg),
+		KnownIP:       pgdb.NewKnownIPRepo(pg),
+		LoginAttempt:  pgdb.NewLoginAttemptRepo(pg),
+		TOTP:          pgdb.NewTOTPRepo(pg),
+		RecoveryCode:  pgdb.NewRecoveryCodeRepo(pg),
+		WebAuthn:      pgdb.NewWebAuthnRepo(pg),
+		Outbox:        pgdb.NewOutboxRepo(pg),
 	}
 }
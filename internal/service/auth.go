@@ -6,57 +6,169 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 	"net/netip"
+	"test_auth/internal/model/dbmodel"
 	"test_auth/internal/repo"
 	"test_auth/internal/repo/pgerrs"
-	"test_auth/pkg/smtp"
+	"test_auth/pkg/signkey"
 	"time"
 )
 
 const (
 	authServicePrefixLog = "/service/auth"
+
+	securityEventTokenReuse = "refresh_token_reuse"
+	securityEventIPChanged  = "refresh_ip_changed"
+
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+	tokenTypeMFA     = "mfa"
 )
 
-var defaultSignMethod = jwt.SigningMethodHS512
+// IPChangePolicy политика для рефреша с нового ip. В режиме IPChangeSubnet рефреш из той же сети разрешен молча,
+// из другой сети запрещен с уведомлением, как в IPChangeDenyNotify
+type IPChangePolicy string
+
+const (
+	IPChangeAllow       IPChangePolicy = "allow"
+	IPChangeAllowNotify IPChangePolicy = "allow-and-notify"
+	IPChangeDenyNotify  IPChangePolicy = "deny-and-notify"
+	IPChangeSubnet      IPChangePolicy = "subnet"
+)
 
 type TokenClaims struct {
 	jwt.StandardClaims
-	UserId   string `json:"user_id"`
-	UserAddr string `json:"user_addr"`
+	UserId    string `json:"user_id"`
+	UserAddr  string `json:"user_addr"`
+	SessionId string `json:"session_id"`
+	PairId    string `json:"pair_id"` // общий идентификатор access и refresh токенов, выданных вместе
+	// Generation номер ротации refresh токена внутри сессии (семьи токенов)
+	Generation int `json:"generation"`
+	// TokenType назначение токена (access, refresh или mfa), чтобы один тип нельзя было выдать за другой
+	TokenType string `json:"typ"`
 }
 
 type authService struct {
+	tx         repo.Transactor
 	user       repo.User
-	smtp       smtp.Smtp
-	signKey    []byte
+	token      repo.UserToken
+	session    repo.Session
+	event      repo.SecurityEvent
+	knownIP    repo.KnownIP
+	mail       *mailer
+	keys       *signkey.KeyRing
 	accessTTL  time.Duration
 	refreshTTL time.Duration
+	mfaTTL     time.Duration
+	issuer     string
+	audience   string
+	ipChange   IPChangeConfig
 }
 
-func newAuthService(user repo.User, smtp smtp.Smtp, signKey string, accessTTL, refreshTTL time.Duration) *authService {
+func newAuthService(tx repo.Transactor, user repo.User, token repo.UserToken, session repo.Session, event repo.SecurityEvent, knownIP repo.KnownIP, mail *mailer,
+	keys *signkey.KeyRing, accessTTL, refreshTTL, mfaTTL time.Duration, issuer, audience string, ipChange IPChangeConfig) *authService {
 	return &authService{
+		tx:         tx,
 		user:       user,
-		smtp:       smtp,
-		signKey:    []byte(signKey),
+		token:      token,
+		session:    session,
+		event:      event,
+		knownIP:    knownIP,
+		mail:       mail,
+		keys:       keys,
 		accessTTL:  accessTTL,
 		refreshTTL: refreshTTL,
+		mfaTTL:     mfaTTL,
+		issuer:     issuer,
+		audience:   audience,
+		ipChange:   ipChange,
 	}
 }
 
-func (s *authService) CreateTokens(ctx context.Context, remoteAddr, userId string) (string, string, error) {
-	return s.newTokenPair(ctx, remoteAddr, userId)
+func (s *authService) CreateTokens(ctx context.Context, input TokenCreateInput) (string, string, error) {
+	sessionId := uuid.NewString()
+
+	access, refresh, hashedRefresh, err := s.newTokenPair(input.IP, input.UserId, sessionId, 0)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.session.Create(ctx, dbmodel.Session{
+		SessionId:    sessionId,
+		UserId:       input.UserId,
+		RefreshToken: hashedRefresh,
+		Device:       input.Device,
+		IP:           input.IP.String(),
+		UserAgent:    input.UserAgent,
+		ExpiresAt:    time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return "", "", ErrUserNotFound
+		}
+		log.Errorf("%s/CreateTokens error create session: %s", authServicePrefixLog, err)
+		return "", "", err
+	}
+	if s.ipChange.KnownIPs {
+		if err = s.knownIP.Remember(ctx, input.UserId, input.IP.String()); err != nil {
+			log.Errorf("%s/CreateTokens error remember user ip: %s", authServicePrefixLog, err)
+		}
+	}
+	return access, refresh, nil
 }
 
-func (s *authService) RefreshToken(ctx context.Context, remoteAddr, refreshToken string) (string, string, error) {
-	claims, err := s.parseToken(refreshToken)
+func (s *authService) RefreshToken(ctx context.Context, ip netip.Addr, accessToken, refreshToken string) (string, string, error) {
+	claims, err := s.parseToken(refreshToken, tokenTypeRefresh)
 	if err != nil {
 		if errors.Is(err, ErrCannotParseToken) {
 			return "", "", ErrCannotRefreshToken
 		}
 		return "", "", err
 	}
+
+	session, err := s.session.FindById(ctx, claims.SessionId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return "", "", ErrSessionNotFound
+		}
+		log.Errorf("%s/RefreshToken error find session: %s", authServicePrefixLog, err)
+		return "", "", ErrCannotRefreshToken
+	}
+	if session.UserId != claims.UserId {
+		return "", "", ErrInvalidToken
+	}
+	if session.RevokedAt != nil {
+		return "", "", ErrSessionRevoked
+	}
+	// подписанный нами токен старого поколения означает, что его уже использовали для рефреша
+	if claims.Generation < session.Generation {
+		s.revokeTokenFamily(ctx, session, claims.Generation, ip)
+		return "", "", ErrTokenReused
+	}
+	// пара проверяется после поколения: украденный старый refresh токен без access токена тоже отзывает семью
+	accessClaims, err := s.parsePairedAccessToken(accessToken)
+	if err != nil {
+		if errors.Is(err, ErrCannotParseToken) {
+			return "", "", ErrCannotRefreshToken
+		}
+		return "", "", err
+	}
+	if claims.PairId == "" || accessClaims.PairId != claims.PairId || accessClaims.UserId != claims.UserId {
+		return "", "", ErrTokenPairMismatch
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return "", "", ErrSessionExpired
+	}
+	if claims.Generation != session.Generation {
+		return "", "", ErrInvalidToken
+	}
+	if err = compareTokenHash(session.RefreshToken, refreshToken); err != nil {
+		return "", "", ErrInvalidToken
+	}
+
 	u, err := s.user.FindById(ctx, claims.UserId)
 	if err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
@@ -66,75 +178,392 @@ func (s *authService) RefreshToken(ctx context.Context, remoteAddr, refreshToken
 		return "", "", ErrCannotRefreshToken
 	}
 
-	refreshTokenShaSum := fmt.Sprintf("%x", sha256.Sum256([]byte(refreshToken)))
-	if err = bcrypt.CompareHashAndPassword([]byte(u.RefreshToken), []byte(refreshTokenShaSum)); err != nil {
-		return "", "", ErrInvalidToken
+	notify := false
+	if claims.UserAddr != ip.String() {
+		var allowed bool
+		allowed, notify = s.addrChangePolicy(ctx, u.UserId, claims.UserAddr, ip)
+		if !allowed {
+			err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+				return s.warnAddrChange(ctx, session, u, claims.UserAddr, ip, false)
+			})
+			if err != nil {
+				log.Errorf("%s/RefreshToken error warn about addr change: %s", authServicePrefixLog, err)
+			}
+			return "", "", ErrAddrChanged
+		}
 	}
 
-	addr, err := netip.ParseAddrPort(remoteAddr)
+	access, refresh, hashedRefresh, err := s.newTokenPair(ip, claims.UserId, session.SessionId, session.Generation+1)
 	if err != nil {
-		log.Errorf("%s/RefreshToken error parse user addr: %s", authServicePrefixLog, err)
 		return "", "", ErrCannotRefreshToken
 	}
-	if claims.UserAddr != addr.Addr().String() {
-		// В реальности, конечно, тут отправляется сообщение в брокер
-		go func() { _ = s.sendWarningMessage(addr.Addr().String(), u.Email) }()
-		return "", "", errors.New("refresh operation from another addr")
-	}
-
-	access, refresh, err := s.newTokenPair(ctx, remoteAddr, claims.UserId)
+	// предупреждение о новом ip сохраняется вместе с ротацией: без письма рефреш не выполнится
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.session.Rotate(ctx, session.SessionId, session.Generation, hashedRefresh, time.Now().Add(s.refreshTTL))
+		if err != nil || !notify {
+			return err
+		}
+		return s.warnAddrChange(ctx, session, u, claims.UserAddr, ip, true)
+	})
 	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return "", "", s.rotateConflict(ctx, session.SessionId, claims.Generation, ip)
+		}
+		log.Errorf("%s/RefreshToken error rotate session: %s", authServicePrefixLog, err)
 		return "", "", ErrCannotRefreshToken
 	}
+	// разрешенный политикой новый адрес тоже становится знакомым
+	if s.ipChange.KnownIPs && claims.UserAddr != ip.String() {
+		if err = s.knownIP.Remember(ctx, u.UserId, ip.String()); err != nil {
+			log.Errorf("%s/RefreshToken error remember user ip: %s", authServicePrefixLog, err)
+		}
+	}
 	return access, refresh, nil
 }
 
-func (s *authService) newTokenPair(ctx context.Context, remoteAddr, userId string) (accessToken string, refreshToken string, err error) {
-	accessToken, err = s.generateToken(remoteAddr, userId, s.accessTTL)
+// rotateConflict разбирает неудачную ротацию: сессию параллельно отозвали (выход, сброс пароля)
+// или этим же токеном параллельно выполнили другой рефреш
+func (s *authService) rotateConflict(ctx context.Context, sessionId string, generation int, ip netip.Addr) error {
+	session, err := s.session.FindById(ctx, sessionId)
 	if err != nil {
-		return "", "", err
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrSessionNotFound
+		}
+		log.Errorf("%s/RefreshToken error find session after rotate: %s", authServicePrefixLog, err)
+		return ErrCannotRefreshToken
+	}
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	if generation < session.Generation {
+		s.revokeTokenFamily(ctx, session, generation, ip)
+		return ErrTokenReused
 	}
+	return ErrCannotRefreshToken
+}
+
+// ValidateAccessToken проверяет подпись, срок действия и claims access токена. Сессия в бд не проверяется,
+// для этого есть ValidateSession
+func (s *authService) ValidateAccessToken(tokenString string) (*TokenClaims, error) {
+	return s.parseToken(tokenString, tokenTypeAccess)
+}
 
-	refreshToken, err = s.generateToken(remoteAddr, userId, s.refreshTTL)
+// ValidateSession проверяет, что сессия access токена не отозвана выходом, рефрешем украденного токена
+// или сбросом пароля и не истекла
+func (s *authService) ValidateSession(ctx context.Context, claims *TokenClaims) error {
+	session, err := s.session.FindById(ctx, claims.SessionId)
 	if err != nil {
-		return "", "", err
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrSessionNotFound
+		}
+		log.Errorf("%s/ValidateSession error find session: %s", authServicePrefixLog, err)
+		return err
+	}
+	if session.UserId != claims.UserId {
+		return ErrInvalidToken
+	}
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
 	}
+	if time.Now().After(session.ExpiresAt) {
+		return ErrSessionExpired
+	}
+	return nil
+}
 
-	// обязательным условием является шифрование токена в бд именно через bcrypt. Однако jwt длиннее чем 72 байта, поэтому предварительно хэшируем sha256
-	refreshTokenShaSum := fmt.Sprintf("%x", sha256.Sum256([]byte(refreshToken)))
+// CreateMFAChallenge токен подтверждает, что пароль уже проверен. Сессия создается только после второго фактора
+func (s *authService) CreateMFAChallenge(userId string, ip netip.Addr) (string, error) {
+	return s.generateToken(tokenTypeMFA, ip, userId, "", "", 0, s.mfaTTL)
+}
 
-	hashedRefresh, err := bcrypt.GenerateFromPassword([]byte(refreshTokenShaSum), bcrypt.DefaultCost)
+// ValidateMFAChallenge возвращает user_id из токена. Токен действует только с того ip, с которого был проверен пароль
+func (s *authService) ValidateMFAChallenge(tokenString string, ip netip.Addr) (string, error) {
+	claims, err := s.parseToken(tokenString, tokenTypeMFA)
 	if err != nil {
-		log.Errorf("%s/newTokenPair error create hash for refresh token: %s", authServicePrefixLog, err)
-		return "", "", err
+		return "", err
+	}
+	if ip.String() != claims.UserAddr {
+		return "", ErrInvalidToken
+	}
+	return claims.UserId, nil
+}
+
+// ConsumeMFAChallenge сохраняет хэш jti mfa токена до истечения его срока, повторное использование токена
+// упирается в уникальный token_hash
+func (s *authService) ConsumeMFAChallenge(ctx context.Context, tokenString string) error {
+	claims, err := s.parseToken(tokenString, tokenTypeMFA)
+	if err != nil {
+		return err
 	}
+	err = s.token.Create(ctx, dbmodel.UserToken{
+		UserId:    claims.UserId,
+		Purpose:   tokenPurposeMFAChallenge,
+		TokenHash: hashUserToken(claims.Id),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrAlreadyExist) {
+			return ErrInvalidToken
+		}
+		log.Errorf("%s/ConsumeMFAChallenge error save used challenge: %s", authServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+func (s *authService) JWKS() signkey.JWKS {
+	return s.keys.JWKS()
+}
 
-	if err = s.user.UpdateToken(ctx, userId, string(hashedRefresh)); err != nil {
+func (s *authService) Sessions(ctx context.Context, userId string) ([]SessionOutput, error) {
+	sessions, err := s.session.FindActiveByUser(ctx, userId)
+	if err != nil {
+		log.Errorf("%s/Sessions error find user sessions: %s", authServicePrefixLog, err)
+		return nil, err
+	}
+	output := make([]SessionOutput, 0, len(sessions))
+	for _, session := range sessions {
+		output = append(output, SessionOutput{
+			SessionId:   session.SessionId,
+			Device:      session.Device,
+			IP:          session.IP,
+			UserAgent:   session.UserAgent,
+			CreatedAt:   session.CreatedAt,
+			RefreshedAt: session.RefreshedAt,
+			ExpiresAt:   session.ExpiresAt,
+		})
+	}
+	return output, nil
+}
+
+func (s *authService) Logout(ctx context.Context, refreshToken string) error {
+	session, err := s.currentSession(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	if err = s.session.Revoke(ctx, session.SessionId); err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
-			return "", "", ErrUserNotFound
+			return ErrSessionRevoked
 		}
-		log.Errorf("%s/newTokenPair error update user refresh token: %s", authServicePrefixLog, err)
-		return "", "", err
+		log.Errorf("%s/Logout error revoke session: %s", authServicePrefixLog, err)
+		return ErrCannotRevokeSession
+	}
+	return nil
+}
+
+func (s *authService) LogoutAll(ctx context.Context, refreshToken string) error {
+	session, err := s.currentSession(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	if err = s.session.RevokeAllByUser(ctx, session.UserId); err != nil {
+		log.Errorf("%s/LogoutAll error revoke user sessions: %s", authServicePrefixLog, err)
+		return ErrCannotRevokeSession
+	}
+	return nil
+}
+
+func (s *authService) RevokeSession(ctx context.Context, refreshToken, sessionId string) error {
+	current, err := s.currentSession(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	target, err := s.session.FindById(ctx, sessionId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrSessionNotFound
+		}
+		log.Errorf("%s/RevokeSession error find session: %s", authServicePrefixLog, err)
+		return ErrCannotRevokeSession
+	}
+	// чужие сессии для пользователя не существуют
+	if target.UserId != current.UserId {
+		return ErrSessionNotFound
+	}
+	if err = s.session.Revoke(ctx, target.SessionId); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrSessionRevoked
+		}
+		log.Errorf("%s/RevokeSession error revoke session: %s", authServicePrefixLog, err)
+		return ErrCannotRevokeSession
+	}
+	return nil
+}
+
+// addrChangePolicy решает по политике смены ip, разрешить ли рефреш с нового адреса и нужно ли предупредить пользователя
+func (s *authService) addrChangePolicy(ctx context.Context, userId, prevAddr string, ip netip.Addr) (allowed, notify bool) {
+	if s.ipChange.KnownIPs {
+		known, err := s.knownIP.IsKnown(ctx, userId, ip.String(), time.Now().Add(-s.ipChange.KnownIPTTL))
+		if err != nil {
+			log.Errorf("%s/addrChangePolicy error check known ip: %s", authServicePrefixLog, err)
+		}
+		if known {
+			return true, false
+		}
+	}
+
+	switch s.ipChange.Policy {
+	case IPChangeAllow:
+		return true, false
+	case IPChangeAllowNotify:
+		return true, true
+	case IPChangeSubnet:
+		if s.sameSubnet(prevAddr, ip) {
+			return true, false
+		}
+	}
+	return false, true
+}
+
+// warnAddrChange записывает событие безопасности и ставит в outbox предупреждение о рефреше с нового ip
+func (s *authService) warnAddrChange(ctx context.Context, session dbmodel.Session, u dbmodel.User, prevAddr string, ip netip.Addr, allowed bool) error {
+	err := s.event.Create(ctx, dbmodel.SecurityEvent{
+		UserId:    u.UserId,
+		SessionId: session.SessionId,
+		Type:      securityEventIPChanged,
+		IP:        ip.String(),
+		Details:   fmt.Sprintf("refresh from %s, tokens issued to %s, allowed: %t", ip, prevAddr, allowed),
+	})
+	if err != nil {
+		return err
+	}
+	return s.sendWarningMessage(ctx, ip.String(), u.Email, u.Locale)
+}
+
+// sameSubnet адреса из одной сети с префиксом SubnetV4 или SubnetV6, адреса разных семейств всегда в разных сетях
+func (s *authService) sameSubnet(prevAddr string, ip netip.Addr) bool {
+	prev, err := netip.ParseAddr(prevAddr)
+	if err != nil || prev.Is4() != ip.Is4() {
+		return false
+	}
+	bits := s.ipChange.SubnetV6
+	if ip.Is4() {
+		bits = s.ipChange.SubnetV4
+	}
+	prefix, err := prev.Prefix(bits)
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(ip)
+}
+
+// currentSession возвращает активную сессию, которой принадлежит актуальный refresh токен
+func (s *authService) currentSession(ctx context.Context, refreshToken string) (dbmodel.Session, error) {
+	claims, err := s.parseToken(refreshToken, tokenTypeRefresh)
+	if err != nil {
+		if errors.Is(err, ErrCannotParseToken) {
+			return dbmodel.Session{}, ErrInvalidToken
+		}
+		return dbmodel.Session{}, err
+	}
+	session, err := s.session.FindById(ctx, claims.SessionId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return dbmodel.Session{}, ErrSessionNotFound
+		}
+		log.Errorf("%s/currentSession error find session: %s", authServicePrefixLog, err)
+		return dbmodel.Session{}, ErrCannotRevokeSession
+	}
+	if session.UserId != claims.UserId || session.Generation != claims.Generation {
+		return dbmodel.Session{}, ErrInvalidToken
+	}
+	if session.RevokedAt != nil {
+		return dbmodel.Session{}, ErrSessionRevoked
+	}
+	if err = compareTokenHash(session.RefreshToken, refreshToken); err != nil {
+		return dbmodel.Session{}, ErrInvalidToken
+	}
+	return session, nil
+}
+
+// revokeTokenFamily отзывает сессию, в которой обнаружено повторное использование refresh токена,
+// записывает событие безопасности и предупреждает пользователя. Все три изменения сохраняются в одной транзакции
+func (s *authService) revokeTokenFamily(ctx context.Context, session dbmodel.Session, generation int, ip netip.Addr) {
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.session.Revoke(ctx, session.SessionId); err != nil && !errors.Is(err, pgerrs.ErrNotFound) {
+			return fmt.Errorf("revoke session: %w", err)
+		}
+		err := s.event.Create(ctx, dbmodel.SecurityEvent{
+			UserId:    session.UserId,
+			SessionId: session.SessionId,
+			Type:      securityEventTokenReuse,
+			IP:        ip.String(),
+			Details:   fmt.Sprintf("refresh token generation %d presented, current generation %d", generation, session.Generation),
+		})
+		if err != nil {
+			return fmt.Errorf("create security event: %w", err)
+		}
+		u, err := s.user.FindById(ctx, session.UserId)
+		if err != nil {
+			return fmt.Errorf("find user: %w", err)
+		}
+		return s.sendTokenReuseMessage(ctx, ip.String(), u.Email, u.Locale)
+	})
+	if err != nil {
+		log.Errorf("%s/revokeTokenFamily error revoke token family: %s", authServicePrefixLog, err)
+	}
+}
+
+// newTokenPair выпускает пару токенов для сессии и возвращает bcrypt хэш refresh токена для сохранения в бд
+func (s *authService) newTokenPair(ip netip.Addr, userId, sessionId string, generation int) (accessToken, refreshToken, hashedRefresh string, err error) {
+	pairId := uuid.NewString()
+
+	accessToken, err = s.generateToken(tokenTypeAccess, ip, userId, sessionId, pairId, generation, s.accessTTL)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	refreshToken, err = s.generateToken(tokenTypeRefresh, ip, userId, sessionId, pairId, generation, s.refreshTTL)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	hashedRefresh, err = hashToken(refreshToken)
+	if err != nil {
+		log.Errorf("%s/newTokenPair error create hash for refresh token: %s", authServicePrefixLog, err)
+		return "", "", "", err
 	}
 	return
 }
 
-func (s *authService) generateToken(remoteAddr, userId string, ttl time.Duration) (string, error) {
-	addr, err := netip.ParseAddrPort(remoteAddr)
+// обязательным условием является шифрование токена в бд именно через bcrypt. Однако jwt длиннее чем 72 байта, поэтому предварительно хэшируем sha256
+func hashToken(token string) (string, error) {
+	tokenShaSum := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(tokenShaSum), bcrypt.DefaultCost)
 	if err != nil {
-		log.Errorf("%s/generateToken error parse addr: %s", authServicePrefixLog, err)
 		return "", err
 	}
-	token := jwt.NewWithClaims(defaultSignMethod, &TokenClaims{
+	return string(hashed), nil
+}
+
+func compareTokenHash(hashedToken, token string) error {
+	tokenShaSum := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+	return bcrypt.CompareHashAndPassword([]byte(hashedToken), []byte(tokenShaSum))
+}
+
+func (s *authService) generateToken(tokenType string, ip netip.Addr, userId, sessionId, pairId string, generation int, ttl time.Duration) (string, error) {
+	key := s.keys.Active()
+	now := time.Now()
+	token := jwt.NewWithClaims(key.Method, &TokenClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(ttl).Unix(),
-			IssuedAt:  time.Now().Unix(),
+			Id:        uuid.NewString(),
+			Issuer:    s.issuer,
+			Audience:  s.audience,
+			Subject:   userId,
+			ExpiresAt: now.Add(ttl).Unix(),
+			IssuedAt:  now.Unix(),
 		},
-		UserId:   userId,
-		UserAddr: addr.Addr().String(),
+		UserId:     userId,
+		UserAddr:   ip.String(),
+		SessionId:  sessionId,
+		PairId:     pairId,
+		Generation: generation,
+		TokenType:  tokenType,
 	})
+	token.Header["kid"] = key.Id
 
-	signedToken, err := token.SignedString(s.signKey)
+	signedToken, err := token.SignedString(key.Private)
 	if err != nil {
 		log.Errorf("%s/generateToken error sign token: %s", authServicePrefixLog, err)
 		return "", err
@@ -142,19 +571,45 @@ func (s *authService) generateToken(remoteAddr, userId string, ttl time.Duration
 	return signedToken, nil
 }
 
-func (s *authService) parseToken(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+// parseToken проверяет подпись, срок действия и обязательные claims токена ожидаемого типа.
+// Токен другого типа отклоняется до любых обращений к бд
+func (s *authService) parseToken(tokenString, tokenType string) (*TokenClaims, error) {
+	return s.parseTokenWith(&jwt.Parser{}, tokenString, tokenType)
+}
+
+// Access токен к моменту рефреша обычно уже истек, поэтому у него проверяем подпись и claims без срока действия
+func (s *authService) parsePairedAccessToken(tokenString string) (*TokenClaims, error) {
+	return s.parseTokenWith(&jwt.Parser{SkipClaimsValidation: true}, tokenString, tokenTypeAccess)
+}
+
+func (s *authService) parseTokenWith(parser *jwt.Parser, tokenString, tokenType string) (*TokenClaims, error) {
+	token, err := parser.ParseWithClaims(tokenString, &TokenClaims{}, func(t *jwt.Token) (interface{}, error) {
+		// токены, выпущенные до появления kid, проверяем активным ключом
+		key := s.keys.Active()
+		if kid, _ := t.Header["kid"].(string); kid != "" {
+			var err error
+			if key, err = s.keys.Lookup(kid); err != nil {
+				return nil, ErrUnknownSignKey
+			}
+		}
+		if t.Method.Alg() != key.Method.Alg() {
 			return nil, ErrIncorrectSignMethod
 		}
-		return s.signKey, nil
+		return key.Public, nil
 	})
 	if err != nil {
-		if !errors.Is(err, ErrIncorrectSignMethod) {
+		// jwt v3 заворачивает ошибку keyfunc в ValidationError без Unwrap, поэтому errors.Is до нее не доходит
+		var ve *jwt.ValidationError
+		if !errors.As(err, &ve) {
 			log.Errorf("%s/parseToken error parse token: %s", authServicePrefixLog, err)
 			return nil, err
 		}
-		return nil, ErrCannotParseToken
+		// истекшие, испорченные и поддельные токены - обычная ситуация, а не ошибка сервиса
+		log.Debugf("%s/parseToken invalid token: %s", authServicePrefixLog, err)
+		if errors.Is(ve.Inner, ErrUnknownSignKey) {
+			return nil, ErrUnknownSignKey
+		}
+		return nil, ErrInvalidToken
 	}
 	if !token.Valid {
 		return nil, ErrInvalidToken
@@ -163,18 +618,48 @@ func (s *authService) parseToken(tokenString string) (*TokenClaims, error) {
 	if !ok {
 		return nil, ErrCannotParseToken
 	}
+	if err = s.verifyClaims(claims, tokenType); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
 
-func (s *authService) sendWarningMessage(addr, to string) error {
-	const template = "Subject: Warning message\n\r" +
-		"Hello from \"Company Name\"! We have noticed suspicious activity on your account. " +
-		"Logged in at %s UTC from the address %s. If it's not you, change your password immediately"
+func (s *authService) verifyClaims(claims *TokenClaims, tokenType string) error {
+	if claims.TokenType != tokenType {
+		return ErrInvalidTokenType
+	}
+	if !claims.VerifyIssuer(s.issuer, true) || !claims.VerifyAudience(s.audience, true) {
+		return ErrInvalidToken
+	}
+	if claims.Id == "" || claims.Subject == "" || claims.Subject != claims.UserId {
+		return ErrInvalidToken
+	}
+	// mfa токен выдается до создания сессии
+	if claims.SessionId == "" && tokenType != tokenTypeMFA {
+		return ErrInvalidToken
+	}
+	return nil
+}
 
-	text := fmt.Sprintf(template, time.Now().UTC().Format("15:04:05 02.01.2006"), addr)
+func (s *authService) sendWarningMessage(ctx context.Context, addr, to, locale string) error {
+	err := s.mail.send(ctx, mailKindIPWarning, to, locale, mailData{
+		Time: time.Now(),
+		Addr: addr,
+	})
+	if err != nil {
+		log.Errorf("%s/sendWarningMessage error enqueue message: %s", authServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
 
-	if err := s.smtp.SendMail(to, text); err != nil {
-		log.Errorf("%s/sendWarningMessage error send smtp message: %s", authServicePrefixLog, err)
+func (s *authService) sendTokenReuseMessage(ctx context.Context, addr, to, locale string) error {
+	err := s.mail.send(ctx, mailKindTokenReuse, to, locale, mailData{
+		Time: time.Now(),
+		Addr: addr,
+	})
+	if err != nil {
+		log.Errorf("%s/sendTokenReuseMessage error enqueue message: %s", authServicePrefixLog, err)
 		return err
 	}
 	return nil
@@ -1,13 +1,60 @@
 package service
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrUserNotFound      = errors.New("user not found")
+	ErrEmailNotVerified  = errors.New("email is not verified")
+	ErrInvalidPassword   = errors.New("invalid password")
+	ErrWeakPassword      = errors.New("weak password")
+	ErrBreachedPassword  = errors.New("password appears in a known data breach, choose another one")
+	ErrSameEmail         = errors.New("new email matches the current one")
+	ErrInvalidUserToken  = errors.New("invalid or expired token")
+	ErrMagicLinkBinding  = errors.New("the link must be opened on the device it was requested from")
+	ErrTooManyAttempts   = errors.New("too many failed sign-in attempts, try again later")
+	ErrAccountLocked     = errors.New("account is temporarily locked, try again later")
+
+	ErrMFANotConfigured  = errors.New("two-factor authentication is not configured")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor authentication code")
+
+	ErrPasskeyNotConfigured = errors.New("passkeys are not configured")
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrPasskeyAlreadyExists = errors.New("passkey already registered")
+	ErrInvalidPasskey       = errors.New("passkey verification failed")
 
 	ErrIncorrectSignMethod = errors.New("incorrect sign method")
+	ErrUnknownSignKey      = errors.New("unknown sign key")
 	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidTokenType    = errors.New("invalid token type")
 	ErrCannotParseToken    = errors.New("cannot parse token")
 	ErrCannotRefreshToken  = errors.New("cannot refresh token")
+	ErrTokenPairMismatch   = errors.New("access and refresh tokens are not paired")
+	ErrAddrChanged         = errors.New("refresh operation from another addr")
+
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session expired")
+	ErrSessionRevoked  = errors.New("session revoked")
+	ErrTokenReused     = errors.New("refresh token reuse detected, session revoked")
+
+	ErrCannotRevokeSession = errors.New("cannot revoke session")
 )
+
+// RetryError сообщает, через сколько можно повторить запрос
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"test_auth/internal/repo"
+	"test_auth/internal/repo/pgerrs"
+	"time"
+)
+
+const (
+	loginKeyUser = "user:"
+	loginKeyIP   = "ip:"
+	loginKeyMFA  = "mfa:"
+)
+
+// loginGuard считает неудачные попытки входа по аккаунту и по ip (и попытки ввода второго фактора). Счетчики хранятся в бд,
+// поэтому ограничения действуют для всех реплик сервиса
+type loginGuard struct {
+	attempts repo.LoginAttempt
+	cfg      LoginThrottleConfig
+}
+
+// check возвращает RetryError, если ключ заблокирован или задержка после прошлой неудачи еще не истекла
+func (g *loginGuard) check(ctx context.Context, key string) error {
+	a, err := g.attempts.Find(ctx, key)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	if a.LockedUntil != nil && now.Before(*a.LockedUntil) {
+		return &RetryError{Err: ErrAccountLocked, RetryAfter: a.LockedUntil.Sub(now)}
+	}
+	if now.Sub(a.LastFailure) > g.cfg.Window {
+		return nil
+	}
+	if next := a.LastFailure.Add(g.delay(a.Failures)); now.Before(next) {
+		return &RetryError{Err: ErrTooManyAttempts, RetryAfter: next.Sub(now)}
+	}
+	return nil
+}
+
+// delay удваивается с каждой неудачей после DelayAfter, но не больше MaxDelay
+func (g *loginGuard) delay(failures int) time.Duration {
+	if g.cfg.DelayAfter <= 0 || failures < g.cfg.DelayAfter {
+		return 0
+	}
+	d := g.cfg.BaseDelay
+	for i := g.cfg.DelayAfter; i < failures && d < g.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDelay)
+}
+
+// fail учитывает неудачную попытку и возвращает true, если этот вызов заблокировал ключ
+func (g *loginGuard) fail(ctx context.Context, key string, lockAfter int) (bool, error) {
+	a, err := g.attempts.RegisterFailure(ctx, key, g.cfg.Window)
+	if err != nil {
+		return false, err
+	}
+	if lockAfter <= 0 || a.Failures < lockAfter {
+		return false, nil
+	}
+	// при параллельных неудачах порог видят несколько запросов, блокирует и сообщает о блокировке только один
+	return g.attempts.Lock(ctx, key, time.Now().Add(g.cfg.LockDuration))
+}
+
+func (g *loginGuard) reset(ctx context.Context, key string) error {
+	return g.attempts.Reset(ctx, key)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo/pgerrs"
+	"time"
+)
+
+// magicLinkBinding сохраняется в payload токена, чтобы при входе проверить привязку к ip и устройству
+type magicLinkBinding struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Device    string `json:"device"`
+}
+
+// SendMagicLink отправляет одноразовую ссылку для входа без пароля. Как и ForgotPassword, результат не зависит
+// от того, зарегистрирован ли адрес, а слишком частые запросы для одного адреса молча игнорируются
+func (s *userService) SendMagicLink(ctx context.Context, input MagicLinkInput) error {
+	u, err := s.user.FindByEmail(ctx, normalizeEmail(input.Email))
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil
+		}
+		log.Errorf("%s/SendMagicLink error find user by email: %s", userServicePrefixLog, err)
+		return err
+	}
+
+	last, err := s.token.LastCreatedAt(ctx, u.UserId, tokenPurposeMagicLink)
+	if err != nil && !errors.Is(err, pgerrs.ErrNotFound) {
+		log.Errorf("%s/SendMagicLink error find last magic link: %s", userServicePrefixLog, err)
+		return err
+	}
+	if err == nil && time.Since(last) < s.magicLink.ResendInterval {
+		return nil
+	}
+
+	payload, err := json.Marshal(magicLinkBinding{
+		IP:        input.IP.String(),
+		UserAgent: input.UserAgent,
+		Device:    input.Device,
+	})
+	if err != nil {
+		return err
+	}
+	token, hash, err := newUserToken()
+	if err != nil {
+		log.Errorf("%s/SendMagicLink error generate token: %s", userServicePrefixLog, err)
+		return err
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.token.Create(ctx, dbmodel.UserToken{
+			UserId:    u.UserId,
+			Purpose:   tokenPurposeMagicLink,
+			TokenHash: hash,
+			Payload:   string(payload),
+			ExpiresAt: time.Now().Add(s.magicLink.TTL),
+		})
+		if err != nil {
+			log.Errorf("%s/SendMagicLink error create token: %s", userServicePrefixLog, err)
+			return err
+		}
+		return s.sendMagicLinkMessage(ctx, u.Email, u.Locale, token)
+	})
+}
+
+// ConsumeMagicLink использует ссылку и возвращает user_id и название устройства из запроса ссылки.
+// Несовпадение ip или устройства (если привязка включена) не расходует ссылку. Переход по ссылке подтверждает почту
+func (s *userService) ConsumeMagicLink(ctx context.Context, input MagicLinkConsumeInput) (string, string, error) {
+	hash := hashUserToken(input.Token)
+	t, err := s.token.Find(ctx, tokenPurposeMagicLink, hash)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return "", "", ErrInvalidUserToken
+		}
+		log.Errorf("%s/ConsumeMagicLink error find token: %s", userServicePrefixLog, err)
+		return "", "", err
+	}
+
+	var binding magicLinkBinding
+	if err = json.Unmarshal([]byte(t.Payload), &binding); err != nil {
+		log.Errorf("%s/ConsumeMagicLink error unmarshal token payload: %s", userServicePrefixLog, err)
+		return "", "", err
+	}
+	if s.magicLink.BindIP && binding.IP != input.IP.String() {
+		return "", "", ErrMagicLinkBinding
+	}
+	if s.magicLink.BindDevice && binding.UserAgent != input.UserAgent {
+		return "", "", ErrMagicLinkBinding
+	}
+
+	if _, err = s.token.Use(ctx, tokenPurposeMagicLink, hash); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return "", "", ErrInvalidUserToken
+		}
+		log.Errorf("%s/ConsumeMagicLink error use token: %s", userServicePrefixLog, err)
+		return "", "", err
+	}
+	// письмо дошло до владельца адреса, поэтому почта считается подтвержденной
+	if err = s.user.SetEmailVerified(ctx, t.UserId); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return "", "", ErrUserNotFound
+		}
+		log.Errorf("%s/ConsumeMagicLink error set email verified: %s", userServicePrefixLog, err)
+		return "", "", err
+	}
+	return t.UserId, binding.Device, nil
+}
+
+func (s *userService) sendMagicLinkMessage(ctx context.Context, to, locale, token string) error {
+	err := s.mail.send(ctx, mailKindMagicLink, to, locale, mailData{
+		Link:  tokenLink(s.magicLink.URL, token),
+		Token: token,
+		TTL:   s.magicLink.TTL,
+	})
+	if err != nil {
+		log.Errorf("%s/sendMagicLinkMessage error enqueue message: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
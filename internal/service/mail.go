@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo"
+	"test_auth/pkg/mail"
+	"time"
+)
+
+// типы писем, совпадают с именами шаблонов и по ним же проще искать сообщения в dead letter outbox
+const (
+	mailKindIPWarning         = "ip_warning"
+	mailKindTokenReuse        = "token_reuse"
+	mailKindLockout           = "account_locked"
+	mailKindVerification      = "email_verification"
+	mailKindPasswordReset     = "password_reset"
+	mailKindEmailChange       = "email_change"
+	mailKindEmailChangeNotice = "email_change_notice"
+	mailKindMagicLink         = "magic_link"
+	mailKindMFAEnabled        = "mfa_enabled"
+	mailKindMFADisabled       = "mfa_disabled"
+	mailKindPasskeyAdded      = "passkey_added"
+	mailKindPasskeyRemoved    = "passkey_removed"
+)
+
+// mailData данные для шаблонов писем, заполняются только поля, нужные конкретному письму.
+// Time и длительности выводятся в шаблонах функциями datetime и duration на языке письма
+type mailData struct {
+	// Time время события
+	Time time.Time
+	// Addr ip адрес, с которого пришел запрос
+	Addr string
+	// Link ссылка с одноразовым токеном, пустая, если страница для ссылки не настроена. Тогда в письмо выводится Token
+	Link  string
+	Token string
+	// TTL время жизни ссылки или токена
+	TTL time.Duration
+	// Duration длительность блокировки аккаунта
+	Duration time.Duration
+	// NewEmail запрошенный новый адрес почты
+	NewEmail string
+	// Name название добавленного или удаленного ключа, может быть пустым
+	Name string
+}
+
+// mailer рендерит письма на языке пользователя и сохраняет их в outbox уже готовыми к отправке,
+// поэтому изменение шаблонов не затрагивает письма, ожидающие повторной попытки
+type mailer struct {
+	outbox    repo.Outbox
+	templates *mail.Templates
+	from      string
+}
+
+func newMailer(outbox repo.Outbox, cfg MailConfig) *mailer {
+	return &mailer{
+		outbox:    outbox,
+		templates: cfg.Templates,
+		from:      cfg.From,
+	}
+}
+
+// send ставит письмо kind в outbox. Внутри WithinTransaction письмо появится только вместе
+// с остальными изменениями транзакции, а отправит его OutboxDispatcher
+func (m *mailer) send(ctx context.Context, kind, to, locale string, data mailData) error {
+	content, err := m.templates.Render(locale, kind, data)
+	if err != nil {
+		return fmt.Errorf("render %s: %w", kind, err)
+	}
+	message, err := mail.Compose(m.from, to, content, time.Now())
+	if err != nil {
+		return fmt.Errorf("compose %s: %w", kind, err)
+	}
+	return m.outbox.Enqueue(ctx, dbmodel.OutboxMessage{
+		Kind:      kind,
+		Recipient: to,
+		Message:   string(message),
+	})
+}
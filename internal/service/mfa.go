@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/netip"
+	"strings"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/totp"
+	"time"
+)
+
+const (
+	mfaServicePrefixLog = "/service/mfa"
+
+	securityEventMFAEnabled       = "mfa_enabled"
+	securityEventMFADisabled      = "mfa_disabled"
+	securityEventRecoveryCodeUsed = "recovery_code_used"
+
+	// допуск в один шаг (30 секунд) в обе стороны на расхождение часов устройства
+	totpSkew = 1
+
+	recoveryCodeBytes = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type mfaService struct {
+	tx       repo.Transactor
+	user     repo.User
+	totp     repo.TOTP
+	recovery repo.RecoveryCode
+	event    repo.SecurityEvent
+	mail     *mailer
+	// users проверяет текущий пароль перед подключением второго фактора
+	users *userService
+	guard *loginGuard
+	cfg   MFAConfig
+}
+
+func newMFAService(tx repo.Transactor, user repo.User, totp repo.TOTP, recovery repo.RecoveryCode, event repo.SecurityEvent,
+	mail *mailer, users *userService, attempts repo.LoginAttempt, throttle LoginThrottleConfig, cfg MFAConfig) *mfaService {
+	return &mfaService{
+		tx:       tx,
+		user:     user,
+		totp:     totp,
+		recovery: recovery,
+		event:    event,
+		mail:     mail,
+		users:    users,
+		guard:    &loginGuard{attempts: attempts, cfg: throttle},
+		cfg:      cfg,
+	}
+}
+
+// Enabled сообщает, подтвержден ли у пользователя второй фактор. Не зависит от наличия ключа шифрования,
+// чтобы потеря конфигурации не отключала 2FA молча
+func (s *mfaService) Enabled(ctx context.Context, userId string) (bool, error) {
+	t, err := s.totp.Find(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return false, nil
+		}
+		log.Errorf("%s/Enabled error find totp: %s", mfaServicePrefixLog, err)
+		return false, err
+	}
+	return t.ConfirmedAt != nil, nil
+}
+
+// EnrollTOTP создает новый секрет после проверки текущего пароля. Второй фактор включается только
+// после ConfirmTOTP, до этого повторный вызов заменяет секрет
+func (s *mfaService) EnrollTOTP(ctx context.Context, input TOTPEnrollInput) (TOTPEnrollOutput, error) {
+	if s.cfg.Cipher == nil {
+		return TOTPEnrollOutput{}, ErrMFANotConfigured
+	}
+	u, err := s.findUser(ctx, "EnrollTOTP", input.UserId)
+	if err != nil {
+		return TOTPEnrollOutput{}, err
+	}
+	if err = s.users.confirmPassword(ctx, "EnrollTOTP", u, input.Password, input.IP); err != nil {
+		return TOTPEnrollOutput{}, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Errorf("%s/EnrollTOTP error generate secret: %s", mfaServicePrefixLog, err)
+		return TOTPEnrollOutput{}, err
+	}
+	encrypted, err := s.cfg.Cipher.Encrypt(secret, []byte(u.UserId))
+	if err != nil {
+		log.Errorf("%s/EnrollTOTP error encrypt secret: %s", mfaServicePrefixLog, err)
+		return TOTPEnrollOutput{}, err
+	}
+	if err = s.totp.Save(ctx, dbmodel.TOTP{UserId: u.UserId, Secret: encrypted}); err != nil {
+		if errors.Is(err, pgerrs.ErrAlreadyExist) {
+			return TOTPEnrollOutput{}, ErrMFAAlreadyEnabled
+		}
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return TOTPEnrollOutput{}, ErrUserNotFound
+		}
+		log.Errorf("%s/EnrollTOTP error save totp: %s", mfaServicePrefixLog, err)
+		return TOTPEnrollOutput{}, err
+	}
+
+	return TOTPEnrollOutput{
+		Secret: totp.EncodeSecret(secret),
+		URI:    totp.URI(s.cfg.Issuer, u.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP включает второй фактор после проверки текущего пароля и первого кода и возвращает одноразовые
+// коды восстановления. Коды показываются только один раз, в бд хранятся их хэши
+func (s *mfaService) ConfirmTOTP(ctx context.Context, input TOTPConfirmInput) ([]string, error) {
+	t, err := s.totp.Find(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil, ErrMFANotEnabled
+		}
+		log.Errorf("%s/ConfirmTOTP error find totp: %s", mfaServicePrefixLog, err)
+		return nil, err
+	}
+	if t.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	u, err := s.findUser(ctx, "ConfirmTOTP", input.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.users.confirmPassword(ctx, "ConfirmTOTP", u, input.Password, input.IP); err != nil {
+		return nil, err
+	}
+	secret, err := s.decryptSecret(t)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, input.Code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes(s.cfg.RecoveryCodes)
+	if err != nil {
+		log.Errorf("%s/ConfirmTOTP error generate recovery codes: %s", mfaServicePrefixLog, err)
+		return nil, err
+	}
+	// коды восстановления, включение и письмо владельцу сохраняются вместе
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.recovery.Replace(ctx, u.UserId, hashes); err != nil {
+			log.Errorf("%s/ConfirmTOTP error save recovery codes: %s", mfaServicePrefixLog, err)
+			return err
+		}
+		if err := s.totp.Confirm(ctx, u.UserId, step); err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrMFAAlreadyEnabled
+			}
+			log.Errorf("%s/ConfirmTOTP error confirm totp: %s", mfaServicePrefixLog, err)
+			return err
+		}
+		return s.notify(ctx, "ConfirmTOTP", u, securityEventMFAEnabled, mailKindMFAEnabled, input.IP)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP выключает второй фактор, подтверждая действие действующим кодом или кодом восстановления
+func (s *mfaService) DisableTOTP(ctx context.Context, userId, code string, ip netip.Addr) error {
+	if err := s.Verify(ctx, userId, code, ip); err != nil {
+		return err
+	}
+	u, err := s.findUser(ctx, "DisableTOTP", userId)
+	if err != nil {
+		return err
+	}
+	// без транзакции сбой между удалениями оставил бы живые коды восстановления при выключенном втором факторе
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.totp.Delete(ctx, userId); err != nil {
+			log.Errorf("%s/DisableTOTP error delete totp: %s", mfaServicePrefixLog, err)
+			return err
+		}
+		if err := s.recovery.DeleteAll(ctx, userId); err != nil {
+			log.Errorf("%s/DisableTOTP error delete recovery codes: %s", mfaServicePrefixLog, err)
+			return err
+		}
+		return s.notify(ctx, "DisableTOTP", u, securityEventMFADisabled, mailKindMFADisabled, ip)
+	})
+}
+
+// notify записывает событие безопасности и предупреждает владельца о включении или выключении второго фактора
+func (s *mfaService) notify(ctx context.Context, method string, u dbmodel.User, eventType, kind string, ip netip.Addr) error {
+	err := s.event.Create(ctx, dbmodel.SecurityEvent{
+		UserId: u.UserId,
+		Type:   eventType,
+		IP:     ip.String(),
+	})
+	if err != nil {
+		log.Errorf("%s/%s error create security event: %s", mfaServicePrefixLog, method, err)
+		return err
+	}
+	err = s.mail.send(ctx, kind, u.Email, u.Locale, mailData{
+		Time: time.Now(),
+		Addr: ip.String(),
+	})
+	if err != nil {
+		log.Errorf("%s/%s error enqueue message: %s", mfaServicePrefixLog, method, err)
+		return err
+	}
+	return nil
+}
+
+func (s *mfaService) findUser(ctx context.Context, method, userId string) (dbmodel.User, error) {
+	u, err := s.user.FindById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return dbmodel.User{}, ErrUserNotFound
+		}
+		log.Errorf("%s/%s error find user by id: %s", mfaServicePrefixLog, method, err)
+		return dbmodel.User{}, err
+	}
+	return u, nil
+}
+
+// Verify проверяет код из приложения или код восстановления. Каждый код принимается один раз,
+// неудачные попытки считаются так же, как при входе по паролю, и о блокировке владелец получает письмо
+func (s *mfaService) Verify(ctx context.Context, userId, code string, ip netip.Addr) error {
+	key := loginKeyMFA + userId
+	if err := s.guard.check(ctx, key); err != nil {
+		var retry *RetryError
+		if !errors.As(err, &retry) {
+			log.Errorf("%s/Verify error check mfa attempts: %s", mfaServicePrefixLog, err)
+		}
+		return err
+	}
+
+	t, err := s.totp.Find(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrMFANotEnabled
+		}
+		log.Errorf("%s/Verify error find totp: %s", mfaServicePrefixLog, err)
+		return err
+	}
+	if t.ConfirmedAt == nil {
+		return ErrMFANotEnabled
+	}
+
+	ok, err := s.verifyCode(ctx, t, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+			locked, err := s.guard.fail(ctx, key, s.guard.cfg.LockAfter)
+			if err != nil || !locked {
+				return err
+			}
+			return s.onLockout(ctx, userId, ip.String())
+		})
+		if err != nil {
+			log.Errorf("%s/Verify error register mfa failure: %s", mfaServicePrefixLog, err)
+		}
+		return ErrInvalidMFACode
+	}
+	if err = s.guard.reset(ctx, key); err != nil {
+		log.Errorf("%s/Verify error reset mfa attempts: %s", mfaServicePrefixLog, err)
+	}
+	return nil
+}
+
+// onLockout записывает событие безопасности и предупреждает владельца, как и блокировка после неверных паролей
+func (s *mfaService) onLockout(ctx context.Context, userId, ip string) error {
+	u, err := s.user.FindById(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("find user: %w", err)
+	}
+	err = s.event.Create(ctx, dbmodel.SecurityEvent{
+		UserId:  userId,
+		Type:    securityEventAccountLocked,
+		IP:      ip,
+		Details: fmt.Sprintf("%d failed two-factor attempts, locked for %s", s.guard.cfg.LockAfter, s.guard.cfg.LockDuration),
+	})
+	if err != nil {
+		return err
+	}
+	return s.mail.send(ctx, mailKindLockout, u.Email, u.Locale, mailData{
+		Time:     time.Now(),
+		Duration: s.guard.cfg.LockDuration,
+		Addr:     ip,
+	})
+}
+
+func (s *mfaService) verifyCode(ctx context.Context, t dbmodel.TOTP, code string) (bool, error) {
+	secret, err := s.decryptSecret(t)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), totpSkew); ok {
+		// повтор уже принятого кода (или более раннего) отклоняется
+		if err = s.totp.UseStep(ctx, t.UserId, step); err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return false, nil
+			}
+			log.Errorf("%s/verifyCode error use totp step: %s", mfaServicePrefixLog, err)
+			return false, err
+		}
+		return true, nil
+	}
+
+	if err = s.recovery.Use(ctx, t.UserId, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return false, nil
+		}
+		log.Errorf("%s/verifyCode error use recovery code: %s", mfaServicePrefixLog, err)
+		return false, err
+	}
+	s.securityEvent(ctx, t.UserId, securityEventRecoveryCodeUsed)
+	return true, nil
+}
+
+func (s *mfaService) decryptSecret(t dbmodel.TOTP) ([]byte, error) {
+	if s.cfg.Cipher == nil {
+		return nil, ErrMFANotConfigured
+	}
+	secret, err := s.cfg.Cipher.Decrypt(t.Secret, []byte(t.UserId))
+	if err != nil {
+		log.Errorf("%s/decryptSecret error decrypt totp secret: %s", mfaServicePrefixLog, err)
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (s *mfaService) securityEvent(ctx context.Context, userId, eventType string) {
+	if err := s.event.Create(ctx, dbmodel.SecurityEvent{UserId: userId, Type: eventType}); err != nil {
+		log.Errorf("%s/securityEvent error create security event: %s", mfaServicePrefixLog, err)
+	}
+}
+
+// newRecoveryCodes коды вида xxxx-xxxx-xxxx-xxxx (80 бит), поэтому как и токены из писем хэшируются sha256
+func newRecoveryCodes(n int) (codes, hashes []string, err error) {
+	codes = make([]string, 0, n)
+	hashes = make([]string, 0, n)
+	b := make([]byte, recoveryCodeBytes)
+	for i := 0; i < n; i++ {
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes = append(codes, raw[:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode код принимается без учета регистра, пробелов и дефисов
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashUserToken(code)
+}
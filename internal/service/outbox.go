@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo"
+	"test_auth/internal/repo/pgerrs"
+	"test_auth/pkg/smtp"
+	"time"
+)
+
+const outboxPrefixLog = "/service/outbox"
+
+// OutboxDispatcher в фоне отправляет письма из outbox. Неудачная отправка повторяется с экспоненциальной задержкой,
+// после MaxAttempts попыток письмо переносится в dead letter
+type OutboxDispatcher struct {
+	outbox repo.Outbox
+	smtp   smtp.Smtp
+	cfg    OutboxConfig
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newOutboxDispatcher(outbox repo.Outbox, smtp smtp.Smtp, cfg OutboxConfig) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		outbox: outbox,
+		smtp:   smtp,
+		cfg:    cfg,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (d *OutboxDispatcher) Start() {
+	go d.run()
+}
+
+// Shutdown прекращает опрос outbox и досылает уже готовые к отправке письма, пока не истечет DrainTimeout.
+// Неотправленные письма остаются в outbox и будут отправлены после перезапуска
+func (d *OutboxDispatcher) Shutdown() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	<-d.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.DrainTimeout)
+	defer cancel()
+	for {
+		claimed, err := d.dispatch(ctx)
+		if err != nil {
+			return err
+		}
+		if claimed == 0 {
+			return nil
+		}
+	}
+}
+
+func (d *OutboxDispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		// полная пачка означает, что в outbox могут быть еще письма
+		for {
+			claimed, err := d.dispatch(context.Background())
+			if err != nil {
+				log.Errorf("%s/run error dispatch messages: %s", outboxPrefixLog, err)
+			}
+			if err != nil || claimed < d.cfg.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// dispatch отправляет одну пачку писем и возвращает количество взятых из outbox
+func (d *OutboxDispatcher) dispatch(ctx context.Context) (int, error) {
+	messages, err := d.outbox.Claim(ctx, d.cfg.BatchSize, d.cfg.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range messages {
+		if ctx.Err() != nil {
+			// письмо останется взятым до истечения Lease, после чего его отправит следующий запуск
+			return len(messages), ctx.Err()
+		}
+		d.deliver(ctx, m)
+	}
+	return len(messages), nil
+}
+
+func (d *OutboxDispatcher) deliver(ctx context.Context, m dbmodel.OutboxMessage) {
+	sendErr := d.smtp.SendMail(m.Recipient, m.Message)
+	if sendErr == nil {
+		if err := d.outbox.Delete(ctx, m.Id); err != nil && !errors.Is(err, pgerrs.ErrNotFound) {
+			log.Errorf("%s/deliver error delete sent message %d: %s", outboxPrefixLog, m.Id, err)
+		}
+		return
+	}
+
+	if m.Attempts >= d.cfg.MaxAttempts {
+		log.Errorf("%s/deliver message %d (%s) moved to dead letter after %d attempts: %s", outboxPrefixLog, m.Id, m.Kind, m.Attempts, sendErr)
+		if err := d.outbox.Bury(ctx, m.Id, sendErr.Error()); err != nil {
+			log.Errorf("%s/deliver error bury message %d: %s", outboxPrefixLog, m.Id, err)
+		}
+		return
+	}
+
+	log.Warnf("%s/deliver error send message %d (%s), attempt %d: %s", outboxPrefixLog, m.Id, m.Kind, m.Attempts, sendErr)
+	if err := d.outbox.Retry(ctx, m.Id, time.Now().Add(d.backoff(m.Attempts)), sendErr.Error()); err != nil {
+		log.Errorf("%s/deliver error schedule retry of message %d: %s", outboxPrefixLog, m.Id, err)
+	}
+}
+
+// backoff задержка перед следующей попыткой: BaseDelay, удваивается с каждой попыткой до MaxDelay
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.BaseDelay
+	for i := 1; i < attempts && delay < d.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.cfg.MaxDelay)
+}
@@ -0,0 +1,453 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"net/netip"
+	"strings"
+	"test_auth/internal/model/dbmodel"
+	"test_auth/internal/repo"
+	"test_auth/internal/repo/pgerrs"
+	"time"
+)
+
+const (
+	passkeyServicePrefixLog = "/service/passkey"
+
+	securityEventPasskeyAdded   = "passkey_added"
+	securityEventPasskeyRemoved = "passkey_removed"
+	securityEventPasskeyCloned  = "passkey_clone_warning"
+
+	challengePurposeRegistration = "registration"
+	challengePurposeLogin        = "login"
+)
+
+type passkeyService struct {
+	tx       repo.Transactor
+	user     repo.User
+	webauthn repo.WebAuthn
+	event    repo.SecurityEvent
+	mail     *mailer
+	// users проверяет текущий пароль перед добавлением и удалением ключей
+	users        *userService
+	verification EmailVerificationConfig
+	rp           *webauthn.WebAuthn
+	ttl          time.Duration
+}
+
+func newPasskeyService(tx repo.Transactor, user repo.User, webauthn repo.WebAuthn, event repo.SecurityEvent, mail *mailer,
+	users *userService, verification EmailVerificationConfig, cfg PasskeyConfig) *passkeyService {
+	return &passkeyService{
+		tx:           tx,
+		user:         user,
+		webauthn:     webauthn,
+		event:        event,
+		mail:         mail,
+		users:        users,
+		verification: verification,
+		rp:           cfg.RelyingParty,
+		ttl:          cfg.ChallengeTTL,
+	}
+}
+
+// passkeyUser пользователь для библиотеки webauthn. В качестве user handle используется user_id,
+// он не содержит персональных данных
+type passkeyUser struct {
+	user        dbmodel.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.user.UserId)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Username != "" {
+		return u.user.Username
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// SecondFactor сообщает, выбран ли хотя бы один ключ пользователя вторым фактором. Не зависит от конфигурации,
+// чтобы ее потеря не отключала второй фактор молча
+func (s *passkeyService) SecondFactor(ctx context.Context, userId string) (bool, error) {
+	credentials, err := s.webauthn.FindCredentialsByUser(ctx, userId)
+	if err != nil {
+		log.Errorf("%s/SecondFactor error find credentials: %s", passkeyServicePrefixLog, err)
+		return false, err
+	}
+	for _, c := range credentials {
+		if c.SecondFactor {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BeginRegistration параметры для navigator.credentials.create после проверки текущего пароля.
+// Уже зарегистрированные ключи исключаются
+func (s *passkeyService) BeginRegistration(ctx context.Context, input PasskeyBeginInput) (PasskeyChallengeOutput, error) {
+	if s.rp == nil {
+		return PasskeyChallengeOutput{}, ErrPasskeyNotConfigured
+	}
+	u, err := s.findUser(ctx, input.UserId, false)
+	if err != nil {
+		return PasskeyChallengeOutput{}, err
+	}
+	if err = s.users.confirmPassword(ctx, "BeginRegistration", u.user, input.Password, input.IP); err != nil {
+		return PasskeyChallengeOutput{}, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+	creation, session, err := s.rp.BeginRegistration(u,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		log.Errorf("%s/BeginRegistration error begin registration: %s", passkeyServicePrefixLog, err)
+		return PasskeyChallengeOutput{}, err
+	}
+	return s.saveChallenge(ctx, input.UserId, challengePurposeRegistration, session, creation)
+}
+
+// FinishRegistration сохраняет ключ после повторной проверки текущего пароля и предупреждает владельца письмом
+func (s *passkeyService) FinishRegistration(ctx context.Context, input PasskeyRegisterInput) error {
+	if s.rp == nil {
+		return ErrPasskeyNotConfigured
+	}
+	u, err := s.findUser(ctx, input.UserId, false)
+	if err != nil {
+		return err
+	}
+	if err = s.users.confirmPassword(ctx, "FinishRegistration", u.user, input.Password, input.IP); err != nil {
+		return err
+	}
+	session, err := s.takeChallenge(ctx, input.ChallengeId, challengePurposeRegistration, input.UserId)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(input.Credential))
+	if err != nil {
+		return ErrInvalidPasskey
+	}
+	credential, err := s.rp.CreateCredential(u, session, parsed)
+	if err != nil {
+		log.Debugf("%s/FinishRegistration error create credential: %s", passkeyServicePrefixLog, err)
+		return ErrInvalidPasskey
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	name := strings.TrimSpace(input.Name)
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.webauthn.CreateCredential(ctx, dbmodel.WebAuthnCredential{
+			UserId:          input.UserId,
+			CredentialId:    credential.ID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			AAGUID:          credential.Authenticator.AAGUID,
+			SignCount:       int64(credential.Authenticator.SignCount),
+			Transports:      strings.Join(transports, ","),
+			BackupEligible:  credential.Flags.BackupEligible,
+			BackupState:     credential.Flags.BackupState,
+			Name:            name,
+			SecondFactor:    input.SecondFactor,
+		})
+		if err != nil {
+			if errors.Is(err, pgerrs.ErrAlreadyExist) {
+				return ErrPasskeyAlreadyExists
+			}
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			log.Errorf("%s/FinishRegistration error create credential: %s", passkeyServicePrefixLog, err)
+			return err
+		}
+		return s.notify(ctx, "FinishRegistration", u.user, securityEventPasskeyAdded, mailKindPasskeyAdded, name, input.IP)
+	})
+}
+
+// BeginLogin параметры для navigator.credentials.get. Без userId начинается вход без пароля (discoverable credentials),
+// с userId - проверка второго фактора одним из ключей, выбранных для этого пользователем
+func (s *passkeyService) BeginLogin(ctx context.Context, userId string) (PasskeyChallengeOutput, error) {
+	if s.rp == nil {
+		return PasskeyChallengeOutput{}, ErrPasskeyNotConfigured
+	}
+	if userId == "" {
+		// ключ - единственный фактор, поэтому проверка пользователя (pin, биометрия) обязательна
+		assertion, session, err := s.rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			log.Errorf("%s/BeginLogin error begin discoverable login: %s", passkeyServicePrefixLog, err)
+			return PasskeyChallengeOutput{}, err
+		}
+		return s.saveChallenge(ctx, "", challengePurposeLogin, session, assertion)
+	}
+
+	u, err := s.findUser(ctx, userId, true)
+	if err != nil {
+		return PasskeyChallengeOutput{}, err
+	}
+	if len(u.credentials) == 0 {
+		return PasskeyChallengeOutput{}, ErrPasskeyNotFound
+	}
+	assertion, session, err := s.rp.BeginLogin(u)
+	if err != nil {
+		log.Errorf("%s/BeginLogin error begin login: %s", passkeyServicePrefixLog, err)
+		return PasskeyChallengeOutput{}, err
+	}
+	return s.saveChallenge(ctx, userId, challengePurposeLogin, session, assertion)
+}
+
+// FinishLogin проверяет подпись ключа и возвращает user_id владельца. UserId должен совпадать с тем,
+// для кого начат вход (пустой для входа без пароля)
+func (s *passkeyService) FinishLogin(ctx context.Context, input PasskeyLoginInput) (string, error) {
+	if s.rp == nil {
+		return "", ErrPasskeyNotConfigured
+	}
+	session, err := s.takeChallenge(ctx, input.ChallengeId, challengePurposeLogin, input.UserId)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(input.Credential))
+	if err != nil {
+		return "", ErrInvalidPasskey
+	}
+
+	var (
+		owner      *passkeyUser
+		credential *webauthn.Credential
+	)
+	if input.UserId == "" {
+		credential, err = s.rp.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			owner, err = s.findUser(ctx, string(userHandle), false)
+			return owner, err
+		}, session, parsed)
+	} else {
+		if owner, err = s.findUser(ctx, input.UserId, true); err != nil {
+			return "", err
+		}
+		credential, err = s.rp.ValidateLogin(owner, session, parsed)
+	}
+	if err != nil {
+		log.Debugf("%s/FinishLogin error validate login: %s", passkeyServicePrefixLog, err)
+		return "", ErrInvalidPasskey
+	}
+
+	// счетчик подписей не вырос: возможно, ключ скопирован
+	if credential.Authenticator.CloneWarning {
+		s.securityEvent(ctx, owner.user.UserId, securityEventPasskeyCloned)
+		return "", ErrInvalidPasskey
+	}
+	// при входе по ключу вместо пароля действует то же правило, что и при входе по паролю
+	if input.UserId == "" && s.verification.Required && !owner.user.EmailVerified {
+		return "", ErrEmailNotVerified
+	}
+	err = s.webauthn.UpdateCredentialUsage(ctx, credential.ID, int64(credential.Authenticator.SignCount), credential.Flags.BackupState)
+	if err != nil {
+		log.Errorf("%s/FinishLogin error update credential usage: %s", passkeyServicePrefixLog, err)
+		return "", err
+	}
+	return owner.user.UserId, nil
+}
+
+func (s *passkeyService) List(ctx context.Context, userId string) ([]PasskeyOutput, error) {
+	credentials, err := s.webauthn.FindCredentialsByUser(ctx, userId)
+	if err != nil {
+		log.Errorf("%s/List error find credentials: %s", passkeyServicePrefixLog, err)
+		return nil, err
+	}
+	output := make([]PasskeyOutput, 0, len(credentials))
+	for _, c := range credentials {
+		output = append(output, PasskeyOutput{
+			Id:           c.Id,
+			Name:         c.Name,
+			Synced:       c.BackupState,
+			SecondFactor: c.SecondFactor,
+			CreatedAt:    c.CreatedAt,
+			LastUsedAt:   c.LastUsedAt,
+		})
+	}
+	return output, nil
+}
+
+// Delete удаляет ключ после проверки текущего пароля и предупреждает владельца письмом
+func (s *passkeyService) Delete(ctx context.Context, input PasskeyDeleteInput) error {
+	u, err := s.user.FindById(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		log.Errorf("%s/Delete error find user by id: %s", passkeyServicePrefixLog, err)
+		return err
+	}
+	if err = s.users.confirmPassword(ctx, "Delete", u, input.Password, input.IP); err != nil {
+		return err
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		name, err := s.webauthn.DeleteCredential(ctx, input.UserId, input.Id)
+		if err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrPasskeyNotFound
+			}
+			log.Errorf("%s/Delete error delete credential: %s", passkeyServicePrefixLog, err)
+			return err
+		}
+		return s.notify(ctx, "Delete", u, securityEventPasskeyRemoved, mailKindPasskeyRemoved, name, input.IP)
+	})
+}
+
+// notify записывает событие безопасности и предупреждает владельца о добавлении или удалении ключа
+func (s *passkeyService) notify(ctx context.Context, method string, u dbmodel.User, eventType, kind, name string, ip netip.Addr) error {
+	err := s.event.Create(ctx, dbmodel.SecurityEvent{
+		UserId:  u.UserId,
+		Type:    eventType,
+		IP:      ip.String(),
+		Details: name,
+	})
+	if err != nil {
+		log.Errorf("%s/%s error create security event: %s", passkeyServicePrefixLog, method, err)
+		return err
+	}
+	err = s.mail.send(ctx, kind, u.Email, u.Locale, mailData{
+		Time: time.Now(),
+		Addr: ip.String(),
+		Name: name,
+	})
+	if err != nil {
+		log.Errorf("%s/%s error enqueue message: %s", passkeyServicePrefixLog, method, err)
+		return err
+	}
+	return nil
+}
+
+// findUser пользователь с ключами для церемоний webauthn, secondFactorOnly оставляет только ключи второго фактора
+func (s *passkeyService) findUser(ctx context.Context, userId string, secondFactorOnly bool) (*passkeyUser, error) {
+	u, err := s.user.FindById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		log.Errorf("%s/findUser error find user by id: %s", passkeyServicePrefixLog, err)
+		return nil, err
+	}
+	stored, err := s.webauthn.FindCredentialsByUser(ctx, userId)
+	if err != nil {
+		log.Errorf("%s/findUser error find credentials: %s", passkeyServicePrefixLog, err)
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, c := range stored {
+		if secondFactorOnly && !c.SecondFactor {
+			continue
+		}
+		var transports []protocol.AuthenticatorTransport
+		for _, t := range strings.Split(c.Transports, ",") {
+			if t != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(t))
+			}
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialId,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: uint32(c.SignCount),
+			},
+		})
+	}
+	return &passkeyUser{user: u, credentials: credentials}, nil
+}
+
+// saveChallenge сессия церемонии хранится в бд, чтобы начало и завершение могли попасть на разные реплики
+func (s *passkeyService) saveChallenge(ctx context.Context, userId, purpose string, session *webauthn.SessionData, options any) (PasskeyChallengeOutput, error) {
+	if err := s.webauthn.DeleteExpiredChallenges(ctx); err != nil {
+		log.Errorf("%s/saveChallenge error delete expired challenges: %s", passkeyServicePrefixLog, err)
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return PasskeyChallengeOutput{}, err
+	}
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		return PasskeyChallengeOutput{}, err
+	}
+
+	challengeId := uuid.NewString()
+	err = s.webauthn.CreateChallenge(ctx, dbmodel.WebAuthnChallenge{
+		Id:          challengeId,
+		UserId:      userId,
+		Purpose:     purpose,
+		SessionData: string(sessionData),
+		ExpiresAt:   time.Now().Add(s.ttl),
+	})
+	if err != nil {
+		log.Errorf("%s/saveChallenge error create challenge: %s", passkeyServicePrefixLog, err)
+		return PasskeyChallengeOutput{}, err
+	}
+	return PasskeyChallengeOutput{
+		ChallengeId: challengeId,
+		Options:     rawOptions,
+	}, nil
+}
+
+func (s *passkeyService) takeChallenge(ctx context.Context, challengeId, purpose, userId string) (webauthn.SessionData, error) {
+	c, err := s.webauthn.TakeChallenge(ctx, challengeId, purpose)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return webauthn.SessionData{}, ErrInvalidPasskey
+		}
+		log.Errorf("%s/takeChallenge error take challenge: %s", passkeyServicePrefixLog, err)
+		return webauthn.SessionData{}, err
+	}
+	if c.UserId != userId {
+		return webauthn.SessionData{}, ErrInvalidPasskey
+	}
+
+	var session webauthn.SessionData
+	if err = json.Unmarshal([]byte(c.SessionData), &session); err != nil {
+		log.Errorf("%s/takeChallenge error unmarshal session: %s", passkeyServicePrefixLog, err)
+		return webauthn.SessionData{}, err
+	}
+	return session, nil
+}
+
+func (s *passkeyService) securityEvent(ctx context.Context, userId, eventType string) {
+	if err := s.event.Create(ctx, dbmodel.SecurityEvent{UserId: userId, Type: eventType}); err != nil {
+		log.Errorf("%s/securityEvent error create security event: %s", passkeyServicePrefixLog, err)
+	}
+}
@@ -2,47 +2,329 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"net/netip"
 	"test_auth/internal/repo"
+	"test_auth/pkg/aesgcm"
+	"test_auth/pkg/breach"
 	"test_auth/pkg/hasher"
+	"test_auth/pkg/mail"
+	"test_auth/pkg/signkey"
 	"test_auth/pkg/smtp"
+	"test_auth/pkg/validator"
 	"time"
 )
 
 type (
 	UserCreateInput struct {
 		Email    string
+		Username string
 		Password string
+		// Locale язык писем, пусто - язык по умолчанию
+		Locale string
+	}
+	// UserVerifyInput для входа достаточно одного из UserId, Email или Username
+	UserVerifyInput struct {
+		UserId   string
+		Email    string
+		Username string
+		Password string
+		IP       netip.Addr
+	}
+	UserChangePasswordInput struct {
+		UserId          string
+		SessionId       string // текущая сессия, остается активной при RevokeOtherSessions
+		CurrentPassword string
+		NewPassword     string
+		IP              netip.Addr
+
+		RevokeOtherSessions bool
+	}
+	UserChangeEmailInput struct {
+		UserId   string
+		NewEmail string
+		Password string
+		IP       netip.Addr
+	}
+	// TOTPEnrollInput подключение второго фактора подтверждается текущим паролем, как смена пароля и почты
+	TOTPEnrollInput struct {
+		UserId   string
+		Password string
+		IP       netip.Addr
+	}
+	TOTPConfirmInput struct {
+		UserId   string
+		Code     string
+		Password string
+		IP       netip.Addr
+	}
+	MagicLinkInput struct {
+		Email     string
+		IP        netip.Addr
+		UserAgent string
+		Device    string
+	}
+	MagicLinkConsumeInput struct {
+		Token     string
+		IP        netip.Addr
+		UserAgent string
+	}
+	// PasskeyBeginInput ключ дает вход без пароля, поэтому его добавление и удаление подтверждается текущим паролем
+	PasskeyBeginInput struct {
+		UserId   string
+		Password string
+		IP       netip.Addr
+	}
+	PasskeyRegisterInput struct {
+		UserId      string
+		ChallengeId string
+		Name        string
+		Credential  []byte // json ответа navigator.credentials.create
+		Password    string
+		IP          netip.Addr
+		// SecondFactor ключ будет запрашиваться вторым фактором после пароля, иначе он служит только для входа без пароля
+		SecondFactor bool
+	}
+	PasskeyDeleteInput struct {
+		UserId   string
+		Id       int
+		Password string
+		IP       netip.Addr
+	}
+	// PasskeyLoginInput UserId пустой для входа без пароля
+	PasskeyLoginInput struct {
+		UserId      string
+		ChallengeId string
+		Credential  []byte // json ответа navigator.credentials.get
+	}
+	TokenCreateInput struct {
+		UserId    string
+		IP        netip.Addr
+		UserAgent string
+		Device    string
+	}
+)
+
+type (
+	UserOutput struct {
+		UserId string
+		Email  string
+		Locale string
+	}
+	TOTPEnrollOutput struct {
+		Secret string // base32 секрет для ручного ввода
+		URI    string // otpauth ссылка, содержимое QR кода
+	}
+	PasskeyChallengeOutput struct {
+		ChallengeId string
+		Options     json.RawMessage // параметры для navigator.credentials.create/get
+	}
+	PasskeyOutput struct {
+		Id           int
+		Name         string
+		Synced       bool
+		SecondFactor bool
+		CreatedAt    time.Time
+		LastUsedAt   *time.Time
+	}
+	SessionOutput struct {
+		SessionId   string
+		Device      string
+		IP          string
+		UserAgent   string
+		CreatedAt   time.Time
+		RefreshedAt time.Time
+		ExpiresAt   time.Time
 	}
 )
 
 type Auth interface {
-	CreateTokens(ctx context.Context, remoteAddr, userId string) (string, string, error)
-	RefreshToken(ctx context.Context, remoteAddr, refreshToken string) (string, string, error)
+	CreateTokens(ctx context.Context, input TokenCreateInput) (string, string, error)
+	RefreshToken(ctx context.Context, ip netip.Addr, accessToken, refreshToken string) (string, string, error)
+	Logout(ctx context.Context, refreshToken string) error
+	LogoutAll(ctx context.Context, refreshToken string) error
+	RevokeSession(ctx context.Context, refreshToken, sessionId string) error
+	ValidateAccessToken(token string) (*TokenClaims, error)
+	ValidateSession(ctx context.Context, claims *TokenClaims) error
+	// CreateMFAChallenge выдает короткоживущий токен между проверкой пароля и второго фактора
+	CreateMFAChallenge(userId string, ip netip.Addr) (string, error)
+	ValidateMFAChallenge(token string, ip netip.Addr) (string, error)
+	// ConsumeMFAChallenge делает mfa токен недействительным после успешной проверки второго фактора
+	ConsumeMFAChallenge(ctx context.Context, token string) error
+	JWKS() signkey.JWKS
+	Sessions(ctx context.Context, userId string) ([]SessionOutput, error)
 }
 
 type User interface {
 	Create(ctx context.Context, input UserCreateInput) (string, error)
-	Verify(ctx context.Context, userId, password string) (bool, error)
+	Verify(ctx context.Context, input UserVerifyInput) (string, bool, error)
+	Find(ctx context.Context, userId string) (UserOutput, error)
+	ChangeLocale(ctx context.Context, userId, locale string) error
+	SendVerification(ctx context.Context, email string) error
+	VerifyEmail(ctx context.Context, token string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	ChangePassword(ctx context.Context, input UserChangePasswordInput) error
+	ChangeEmail(ctx context.Context, input UserChangeEmailInput) error
+	ConfirmEmailChange(ctx context.Context, token string) error
+	SendMagicLink(ctx context.Context, input MagicLinkInput) error
+	// ConsumeMagicLink возвращает user_id и название устройства, указанное при запросе ссылки
+	ConsumeMagicLink(ctx context.Context, input MagicLinkConsumeInput) (string, string, error)
+}
+
+type MFA interface {
+	Enabled(ctx context.Context, userId string) (bool, error)
+	EnrollTOTP(ctx context.Context, input TOTPEnrollInput) (TOTPEnrollOutput, error)
+	ConfirmTOTP(ctx context.Context, input TOTPConfirmInput) ([]string, error)
+	DisableTOTP(ctx context.Context, userId, code string, ip netip.Addr) error
+	Verify(ctx context.Context, userId, code string, ip netip.Addr) error
+}
+
+type Passkey interface {
+	// SecondFactor сообщает, есть ли у пользователя ключи, выбранные вторым фактором
+	SecondFactor(ctx context.Context, userId string) (bool, error)
+	BeginRegistration(ctx context.Context, input PasskeyBeginInput) (PasskeyChallengeOutput, error)
+	FinishRegistration(ctx context.Context, input PasskeyRegisterInput) error
+	BeginLogin(ctx context.Context, userId string) (PasskeyChallengeOutput, error)
+	FinishLogin(ctx context.Context, input PasskeyLoginInput) (string, error)
+	List(ctx context.Context, userId string) ([]PasskeyOutput, error)
+	Delete(ctx context.Context, input PasskeyDeleteInput) error
 }
 
+type (
+	EmailVerificationConfig struct {
+		TTL            time.Duration
+		ResendInterval time.Duration
+		// Required запрещает вход до подтверждения почты
+		Required bool
+		// URL страница подтверждения, токен передается в query параметре token
+		URL string
+	}
+	PasswordResetConfig struct {
+		TTL            time.Duration
+		ResendInterval time.Duration
+		// URL страница ввода нового пароля, токен передается в query параметре token
+		URL string
+	}
+	EmailChangeConfig struct {
+		TTL time.Duration
+		// URL страница подтверждения нового адреса, токен передается в query параметре token
+		URL string
+	}
+	// IPChangeConfig что делать, если refresh пришел не с того ip, которому выданы токены
+	IPChangeConfig struct {
+		Policy IPChangePolicy
+		// SubnetV4 и SubnetV6 длина префикса сети для IPChangeSubnet
+		SubnetV4 int
+		SubnetV6 int
+		// KnownIPs адреса, с которых пользователь уже входил за последние KnownIPTTL, не считаются сменой ip
+		KnownIPs   bool
+		KnownIPTTL time.Duration
+	}
+	MailConfig struct {
+		// Templates шаблоны писем, встроенные и переопределенные оператором
+		Templates *mail.Templates
+		// From адрес отправителя в заголовке From
+		From string
+	}
+	OutboxConfig struct {
+		PollInterval time.Duration
+		BatchSize    int
+		// Lease время, на которое письмо резервируется за отправителем
+		Lease        time.Duration
+		MaxAttempts  int
+		BaseDelay    time.Duration
+		MaxDelay     time.Duration
+		DrainTimeout time.Duration
+	}
+	MagicLinkConfig struct {
+		TTL            time.Duration
+		ResendInterval time.Duration
+		// URL страница входа, токен передается в query параметре token
+		URL string
+		// BindIP и BindDevice требуют открыть ссылку с того же ip и того же User-Agent, что и при запросе
+		BindIP     bool
+		BindDevice bool
+	}
+	BreachCheckConfig struct {
+		// Checker локальный набор утекших паролей, nil отключает проверку
+		Checker breach.Checker
+		// Reject запрещает утекшие пароли, иначе вход разрешается с предупреждением в логе и security_events
+		Reject bool
+	}
+	MFAConfig struct {
+		// Cipher шифрует секреты TOTP в бд, nil запрещает подключение второго фактора
+		Cipher *aesgcm.Cipher
+		// Issuer название сервиса в приложении-аутентификаторе
+		Issuer        string
+		ChallengeTTL  time.Duration
+		RecoveryCodes int
+	}
+	PasskeyConfig struct {
+		// RelyingParty nil запрещает регистрацию ключей и вход по ним
+		RelyingParty *webauthn.WebAuthn
+		ChallengeTTL time.Duration
+	}
+	LoginThrottleConfig struct {
+		// Window счетчик неудач сбрасывается, если с последней неудачи прошло больше Window
+		Window time.Duration
+		// DelayAfter после стольких неудач между попытками выдерживается задержка, удваивающаяся с каждой неудачей
+		DelayAfter int
+		BaseDelay  time.Duration
+		MaxDelay   time.Duration
+		// LockAfter и IPLockAfter число неудач до временной блокировки аккаунта и ip, 0 отключает блокировку
+		LockAfter    int
+		IPLockAfter  int
+		LockDuration time.Duration
+	}
+)
+
 type (
 	Services struct {
-		Auth Auth
-		User User
+		Auth    Auth
+		User    User
+		MFA     MFA
+		Passkey Passkey
+		Outbox  *OutboxDispatcher
 	}
 	ServicesDependencies struct {
 		Repos      *repo.Repositories
 		Smtp       smtp.Smtp
 		Hasher     hasher.Hasher
-		SignKey    string
+		Keys       *signkey.KeyRing
 		AccessTTL  time.Duration
 		RefreshTTL time.Duration
+		Issuer     string
+		Audience   string
+		IPChange   IPChangeConfig
+
+		EmailVerification EmailVerificationConfig
+		PasswordReset     PasswordResetConfig
+		EmailChange       EmailChangeConfig
+		MagicLink         MagicLinkConfig
+		LoginThrottle     LoginThrottleConfig
+		PasswordPolicy    validator.PasswordPolicy
+		BreachCheck       BreachCheckConfig
+		MFA               MFAConfig
+		Passkey           PasskeyConfig
+		Mail              MailConfig
+		Outbox            OutboxConfig
 	}
 )
 
 func NewServices(d *ServicesDependencies) *Services {
+	mailer := newMailer(d.Repos.Outbox, d.Mail)
+	user := newUserService(d.Repos.Transactor, d.Repos.User, d.Repos.UserToken, d.Repos.Session, d.Repos.SecurityEvent, d.Repos.LoginAttempt,
+		mailer, d.Hasher, d.PasswordPolicy, d.BreachCheck, d.EmailVerification, d.PasswordReset, d.EmailChange, d.MagicLink, d.LoginThrottle)
 	return &Services{
-		Auth: newAuthService(d.Repos.User, d.Smtp, d.SignKey, d.AccessTTL, d.RefreshTTL),
-		User: newUserService(d.Repos.User, d.Hasher),
+		Auth: newAuthService(d.Repos.Transactor, d.Repos.User, d.Repos.UserToken, d.Repos.Session, d.Repos.SecurityEvent, d.Repos.KnownIP, mailer, d.Keys,
+			d.AccessTTL, d.RefreshTTL, d.MFA.ChallengeTTL, d.Issuer, d.Audience, d.IPChange),
+		User: user,
+		MFA: newMFAService(d.Repos.Transactor, d.Repos.User, d.Repos.TOTP, d.Repos.RecoveryCode, d.Repos.SecurityEvent, mailer, user,
+			d.Repos.LoginAttempt, d.LoginThrottle, d.MFA),
+		Passkey: newPasskeyService(d.Repos.Transactor, d.Repos.User, d.Repos.WebAuthn, d.Repos.SecurityEvent, mailer, user,
+			d.EmailVerification, d.Passkey),
+		Outbox: newOutboxDispatcher(d.Repos.Outbox, d.Smtp, d.Outbox),
 	}
 }
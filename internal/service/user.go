@@ -3,34 +3,89 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"net/netip"
+	"strings"
+	"sync"
 	"test_auth/internal/model/dbmodel"
 	"test_auth/internal/repo"
 	"test_auth/internal/repo/pgerrs"
 	"test_auth/pkg/hasher"
+	"test_auth/pkg/mail"
+	"test_auth/pkg/validator"
+	"time"
 )
 
-const userServicePrefixLog = "/service/user"
+const (
+	userServicePrefixLog = "/service/user"
+
+	securityEventAccountLocked    = "account_locked"
+	securityEventBreachedPassword = "breached_password"
+)
 
 type userService struct {
-	user   repo.User
-	hasher hasher.Hasher
+	tx            repo.Transactor
+	user          repo.User
+	token         repo.UserToken
+	session       repo.Session
+	event         repo.SecurityEvent
+	mail          *mailer
+	guard         *loginGuard
+	hasher        hasher.Hasher
+	policy        validator.PasswordPolicy
+	breachCheck   BreachCheckConfig
+	verification  EmailVerificationConfig
+	passwordReset PasswordResetConfig
+	emailChange   EmailChangeConfig
+	magicLink     MagicLinkConfig
+
+	dummyOnce sync.Once
+	dummy     string
 }
 
-func newUserService(user repo.User, hasher hasher.Hasher) *userService {
+func newUserService(tx repo.Transactor, user repo.User, token repo.UserToken, session repo.Session, event repo.SecurityEvent, attempts repo.LoginAttempt,
+	mail *mailer, hasher hasher.Hasher, policy validator.PasswordPolicy, breachCheck BreachCheckConfig, verification EmailVerificationConfig,
+	passwordReset PasswordResetConfig, emailChange EmailChangeConfig, magicLink MagicLinkConfig, throttle LoginThrottleConfig) *userService {
 	return &userService{
-		user:   user,
-		hasher: hasher,
+		tx:            tx,
+		user:          user,
+		token:         token,
+		session:       session,
+		event:         event,
+		mail:          mail,
+		guard:         &loginGuard{attempts: attempts, cfg: throttle},
+		hasher:        hasher,
+		policy:        policy,
+		breachCheck:   breachCheck,
+		verification:  verification,
+		passwordReset: passwordReset,
+		emailChange:   emailChange,
+		magicLink:     magicLink,
 	}
 }
 
 func (s *userService) Create(ctx context.Context, input UserCreateInput) (string, error) {
+	email := normalizeEmail(input.Email)
+	breached, err := s.checkPassword(input.Password, email)
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := s.hasher.Hash(input.Password)
+	if err != nil {
+		log.Errorf("%s/Create error hash password: %s", userServicePrefixLog, err)
+		return "", err
+	}
+
 	userId := uuid.NewString()
-	err := s.user.Create(ctx, dbmodel.User{
+	locale := mail.NormalizeLocale(input.Locale)
+	err = s.user.Create(ctx, dbmodel.User{
 		UserId:   userId,
-		Email:    input.Email,
-		Password: s.hasher.Hash(input.Password),
+		Email:    email,
+		Username: strings.TrimSpace(input.Username),
+		Password: hashedPassword,
+		Locale:   locale,
 	})
 	if err != nil {
 		if errors.Is(err, pgerrs.ErrAlreadyExist) {
@@ -39,17 +94,598 @@ func (s *userService) Create(ctx context.Context, input UserCreateInput) (string
 		log.Errorf("%s/Create error create user: %s", userServicePrefixLog, err)
 		return "", err
 	}
+	if breached {
+		s.warnBreachedPassword(ctx, userId)
+	}
+
+	// письмо можно запросить повторно, поэтому ошибка не отменяет регистрацию
+	if err = s.issueVerification(ctx, userId, email, locale); err != nil {
+		log.Errorf("%s/Create error issue email verification: %s", userServicePrefixLog, err)
+	}
 	return userId, nil
 }
 
-func (s *userService) Verify(ctx context.Context, userId, password string) (bool, error) {
+// Verify проверяет пароль пользователя, найденного по user_id, email или username (в этом порядке),
+// и возвращает его user_id. Неудачные попытки считаются по аккаунту и по ip, при превышении порогов
+// возвращается RetryError с ErrTooManyAttempts или ErrAccountLocked
+func (s *userService) Verify(ctx context.Context, input UserVerifyInput) (string, bool, error) {
+	ip := input.IP.String()
+	ipKey := loginKeyIP + ip
+	if err := s.guard.check(ctx, ipKey); err != nil {
+		return "", false, s.guardError("Verify", err)
+	}
+
+	u, err := s.findByLogin(ctx, input)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			// неизвестный логин неотличим от неверного пароля ни по ответу, ни по времени проверки
+			s.hasher.Verify(input.Password, s.dummyHash())
+			s.failLogin(ctx, nil, ipKey, ip)
+			return "", false, nil
+		}
+		log.Errorf("%s/Verify error find user: %s", userServicePrefixLog, err)
+		return "", false, err
+	}
+
+	userKey := loginKeyUser + u.UserId
+	if err = s.guard.check(ctx, userKey); err != nil {
+		return "", false, s.guardError("Verify", err)
+	}
+	if !s.hasher.Verify(input.Password, u.Password) {
+		s.failLogin(ctx, &u, ipKey, ip)
+		return "", false, nil
+	}
+	if err = s.guard.reset(ctx, userKey); err != nil {
+		log.Errorf("%s/Verify error reset login attempts: %s", userServicePrefixLog, err)
+	}
+	if s.hasher.NeedsRehash(u.Password) {
+		s.rehash(ctx, u.UserId, input.Password)
+	}
+	// проверяем после пароля, чтобы не раскрывать состояние чужого аккаунта
+	if s.verification.Required && !u.EmailVerified {
+		return "", false, ErrEmailNotVerified
+	}
+	return u.UserId, true, nil
+}
+
+// confirmPassword проверяет текущий пароль перед изменением аккаунта. Неудачи считаются тем же loginGuard,
+// что и при входе, поэтому украденный access токен не позволяет подбирать пароль без ограничений
+func (s *userService) confirmPassword(ctx context.Context, method string, u dbmodel.User, password string, addr netip.Addr) error {
+	ip := addr.String()
+	ipKey, userKey := loginKeyIP+ip, loginKeyUser+u.UserId
+	if err := s.guard.check(ctx, ipKey); err != nil {
+		return s.guardError(method, err)
+	}
+	if err := s.guard.check(ctx, userKey); err != nil {
+		return s.guardError(method, err)
+	}
+	if !s.hasher.Verify(password, u.Password) {
+		s.failLogin(ctx, &u, ipKey, ip)
+		return ErrInvalidPassword
+	}
+	if err := s.guard.reset(ctx, userKey); err != nil {
+		log.Errorf("%s/%s error reset login attempts: %s", userServicePrefixLog, method, err)
+	}
+	return nil
+}
+
+// dummyHash хэш случайного пароля текущим алгоритмом для проверки пароля несуществующего пользователя
+func (s *userService) dummyHash() string {
+	s.dummyOnce.Do(func() {
+		hash, err := s.hasher.Hash(uuid.NewString())
+		if err != nil {
+			log.Errorf("%s/dummyHash error hash password: %s", userServicePrefixLog, err)
+			return
+		}
+		s.dummy = hash
+	})
+	return s.dummy
+}
+
+func (s *userService) guardError(method string, err error) error {
+	var retry *RetryError
+	if !errors.As(err, &retry) {
+		log.Errorf("%s/%s error check login attempts: %s", userServicePrefixLog, method, err)
+	}
+	return err
+}
+
+// failLogin учитывает неудачную попытку для ip и, если аккаунт известен, для аккаунта.
+// Блокировка аккаунта сохраняется в одной транзакции с событием безопасности и письмом владельцу
+func (s *userService) failLogin(ctx context.Context, u *dbmodel.User, ipKey, ip string) {
+	ipLocked, err := s.guard.fail(ctx, ipKey, s.guard.cfg.IPLockAfter)
+	if err != nil {
+		log.Errorf("%s/failLogin error register ip failure: %s", userServicePrefixLog, err)
+	}
+	if ipLocked {
+		log.Warnf("%s/failLogin sign-in from %s locked for %s", userServicePrefixLog, ip, s.guard.cfg.LockDuration)
+	}
+	if u == nil {
+		return
+	}
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		locked, err := s.guard.fail(ctx, loginKeyUser+u.UserId, s.guard.cfg.LockAfter)
+		if err != nil || !locked {
+			return err
+		}
+		return s.onLockout(ctx, *u, ip)
+	})
+	if err != nil {
+		log.Errorf("%s/failLogin error register user failure: %s", userServicePrefixLog, err)
+	}
+}
+
+// onLockout записывает событие безопасности и предупреждает владельца заблокированного аккаунта
+func (s *userService) onLockout(ctx context.Context, u dbmodel.User, ip string) error {
+	err := s.event.Create(ctx, dbmodel.SecurityEvent{
+		UserId:  u.UserId,
+		Type:    securityEventAccountLocked,
+		IP:      ip,
+		Details: fmt.Sprintf("%d failed sign-in attempts, locked for %s", s.guard.cfg.LockAfter, s.guard.cfg.LockDuration),
+	})
+	if err != nil {
+		return err
+	}
+	return s.sendLockoutMessage(ctx, u.Email, u.Locale, ip)
+}
+
+func (s *userService) sendLockoutMessage(ctx context.Context, to, locale, addr string) error {
+	err := s.mail.send(ctx, mailKindLockout, to, locale, mailData{
+		Time:     time.Now(),
+		Duration: s.guard.cfg.LockDuration,
+		Addr:     addr,
+	})
+	if err != nil {
+		log.Errorf("%s/sendLockoutMessage error enqueue message: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+func (s *userService) findByLogin(ctx context.Context, input UserVerifyInput) (dbmodel.User, error) {
+	switch {
+	case input.UserId != "":
+		return s.user.FindById(ctx, input.UserId)
+	case input.Email != "":
+		return s.user.FindByEmail(ctx, normalizeEmail(input.Email))
+	case input.Username != "":
+		return s.user.FindByUsername(ctx, strings.TrimSpace(input.Username))
+	default:
+		return dbmodel.User{}, pgerrs.ErrNotFound
+	}
+}
+
+// rehash переводит пароль на текущий алгоритм и параметры хэширования. Ошибка не мешает входу,
+// попытка повторится при следующем входе
+func (s *userService) rehash(ctx context.Context, userId, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Errorf("%s/rehash error hash password: %s", userServicePrefixLog, err)
+		return
+	}
+	if err = s.user.UpdatePassword(ctx, userId, hashedPassword); err != nil {
+		log.Errorf("%s/rehash error update password: %s", userServicePrefixLog, err)
+	}
+}
+
+func (s *userService) Find(ctx context.Context, userId string) (UserOutput, error) {
 	u, err := s.user.FindById(ctx, userId)
 	if err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
-			return false, ErrUserNotFound
+			return UserOutput{}, ErrUserNotFound
 		}
-		log.Errorf("%s/Verify error find user by id: %s", userServicePrefixLog, err)
-		return false, err
+		log.Errorf("%s/Find error find user by id: %s", userServicePrefixLog, err)
+		return UserOutput{}, err
+	}
+	return UserOutput{
+		UserId: u.UserId,
+		Email:  u.Email,
+		Locale: u.Locale,
+	}, nil
+}
+
+// ChangeLocale меняет язык писем пользователя, пустая строка возвращает язык по умолчанию
+func (s *userService) ChangeLocale(ctx context.Context, userId, locale string) error {
+	if err := s.user.UpdateLocale(ctx, userId, mail.NormalizeLocale(locale)); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		log.Errorf("%s/ChangeLocale error update locale: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// SendVerification повторно отправляет письмо для подтверждения почты. Для неизвестного или уже
+// подтвержденного адреса ничего не делает, а слишком частые запросы молча игнорируются, чтобы ответ
+// не раскрывал наличие аккаунта
+func (s *userService) SendVerification(ctx context.Context, email string) error {
+	u, err := s.user.FindByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil
+		}
+		log.Errorf("%s/SendVerification error find user by email: %s", userServicePrefixLog, err)
+		return err
+	}
+	if u.EmailVerified {
+		return nil
 	}
-	return s.hasher.Verify(password, u.Password), nil
+
+	last, err := s.token.LastCreatedAt(ctx, u.UserId, tokenPurposeEmailVerification)
+	if err != nil && !errors.Is(err, pgerrs.ErrNotFound) {
+		log.Errorf("%s/SendVerification error find last verification: %s", userServicePrefixLog, err)
+		return err
+	}
+	if err == nil && time.Since(last) < s.verification.ResendInterval {
+		return nil
+	}
+	return s.issueVerification(ctx, u.UserId, u.Email, u.Locale)
+}
+
+func (s *userService) VerifyEmail(ctx context.Context, token string) error {
+	t, err := s.token.Use(ctx, tokenPurposeEmailVerification, hashUserToken(token))
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrInvalidUserToken
+		}
+		log.Errorf("%s/VerifyEmail error use verification token: %s", userServicePrefixLog, err)
+		return err
+	}
+	if err = s.user.SetEmailVerified(ctx, t.UserId); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		log.Errorf("%s/VerifyEmail error set email verified: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// issueVerification заменяет ранее отправленные токены подтверждения новым и отправляет письмо
+func (s *userService) issueVerification(ctx context.Context, userId, email, locale string) error {
+	token, hash, err := newUserToken()
+	if err != nil {
+		return err
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.token.RevokeAll(ctx, userId, tokenPurposeEmailVerification); err != nil {
+			return err
+		}
+		err := s.token.Create(ctx, dbmodel.UserToken{
+			UserId:    userId,
+			Purpose:   tokenPurposeEmailVerification,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(s.verification.TTL),
+		})
+		if err != nil {
+			return err
+		}
+		return s.sendVerificationMessage(ctx, email, locale, token)
+	})
+}
+
+func (s *userService) sendVerificationMessage(ctx context.Context, to, locale, token string) error {
+	err := s.mail.send(ctx, mailKindVerification, to, locale, mailData{
+		Link:  tokenLink(s.verification.URL, token),
+		Token: token,
+		TTL:   s.verification.TTL,
+	})
+	if err != nil {
+		log.Errorf("%s/sendVerificationMessage error enqueue message: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// ForgotPassword отправляет письмо со ссылкой для сброса пароля. Результат не зависит от того,
+// зарегистрирован ли адрес, а слишком частые запросы молча игнорируются по той же причине
+func (s *userService) ForgotPassword(ctx context.Context, email string) error {
+	u, err := s.user.FindByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil
+		}
+		log.Errorf("%s/ForgotPassword error find user by email: %s", userServicePrefixLog, err)
+		return err
+	}
+
+	last, err := s.token.LastCreatedAt(ctx, u.UserId, tokenPurposePasswordReset)
+	if err != nil && !errors.Is(err, pgerrs.ErrNotFound) {
+		log.Errorf("%s/ForgotPassword error find last reset token: %s", userServicePrefixLog, err)
+		return err
+	}
+	if err == nil && time.Since(last) < s.passwordReset.ResendInterval {
+		return nil
+	}
+
+	token, hash, err := newUserToken()
+	if err != nil {
+		log.Errorf("%s/ForgotPassword error generate reset token: %s", userServicePrefixLog, err)
+		return err
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.token.RevokeAll(ctx, u.UserId, tokenPurposePasswordReset); err != nil {
+			log.Errorf("%s/ForgotPassword error revoke previous reset tokens: %s", userServicePrefixLog, err)
+			return err
+		}
+		err := s.token.Create(ctx, dbmodel.UserToken{
+			UserId:    u.UserId,
+			Purpose:   tokenPurposePasswordReset,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(s.passwordReset.TTL),
+		})
+		if err != nil {
+			log.Errorf("%s/ForgotPassword error create reset token: %s", userServicePrefixLog, err)
+			return err
+		}
+		return s.sendPasswordResetMessage(ctx, u.Email, u.Locale, token)
+	})
+}
+
+// ResetPassword устанавливает новый пароль по токену из письма и отзывает все сессии пользователя.
+// Пароль проверяется до использования токена, чтобы слабый пароль не сжигал ссылку
+func (s *userService) ResetPassword(ctx context.Context, token, password string) error {
+	t, err := s.token.Find(ctx, tokenPurposePasswordReset, hashUserToken(token))
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrInvalidUserToken
+		}
+		log.Errorf("%s/ResetPassword error find reset token: %s", userServicePrefixLog, err)
+		return err
+	}
+	u, err := s.user.FindById(ctx, t.UserId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		log.Errorf("%s/ResetPassword error find user by id: %s", userServicePrefixLog, err)
+		return err
+	}
+	breached, err := s.checkPassword(password, u.Email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Errorf("%s/ResetPassword error hash password: %s", userServicePrefixLog, err)
+		return err
+	}
+	// токен, пароль и сессии меняются вместе: неудача не должна сжечь ссылку или оставить живыми старые сессии
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.token.Use(ctx, tokenPurposePasswordReset, t.TokenHash); err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrInvalidUserToken
+			}
+			log.Errorf("%s/ResetPassword error use reset token: %s", userServicePrefixLog, err)
+			return err
+		}
+		if err := s.user.UpdatePassword(ctx, t.UserId, hashedPassword); err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			log.Errorf("%s/ResetPassword error update password: %s", userServicePrefixLog, err)
+			return err
+		}
+		if err := s.session.RevokeAllByUser(ctx, t.UserId); err != nil {
+			log.Errorf("%s/ResetPassword error revoke user sessions: %s", userServicePrefixLog, err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if breached {
+		s.warnBreachedPassword(ctx, t.UserId)
+	}
+	return nil
+}
+
+func (s *userService) sendPasswordResetMessage(ctx context.Context, to, locale, token string) error {
+	err := s.mail.send(ctx, mailKindPasswordReset, to, locale, mailData{
+		Link:  tokenLink(s.passwordReset.URL, token),
+		Token: token,
+		TTL:   s.passwordReset.TTL,
+	})
+	if err != nil {
+		log.Errorf("%s/sendPasswordResetMessage error enqueue message: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// ChangePassword меняет пароль после проверки текущего. Другие сессии пользователя отзываются по запросу
+func (s *userService) ChangePassword(ctx context.Context, input UserChangePasswordInput) error {
+	u, err := s.user.FindById(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		log.Errorf("%s/ChangePassword error find user by id: %s", userServicePrefixLog, err)
+		return err
+	}
+	if err = s.confirmPassword(ctx, "ChangePassword", u, input.CurrentPassword, input.IP); err != nil {
+		return err
+	}
+	breached, err := s.checkPassword(input.NewPassword, u.Email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(input.NewPassword)
+	if err != nil {
+		log.Errorf("%s/ChangePassword error hash password: %s", userServicePrefixLog, err)
+		return err
+	}
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.user.UpdatePassword(ctx, u.UserId, hashedPassword); err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			log.Errorf("%s/ChangePassword error update password: %s", userServicePrefixLog, err)
+			return err
+		}
+		// ссылки сброса, выданные для старого пароля, больше не нужны
+		if err := s.token.RevokeAll(ctx, u.UserId, tokenPurposePasswordReset); err != nil {
+			log.Errorf("%s/ChangePassword error revoke reset tokens: %s", userServicePrefixLog, err)
+			return err
+		}
+		if input.RevokeOtherSessions {
+			if err := s.session.RevokeOthers(ctx, u.UserId, input.SessionId); err != nil {
+				log.Errorf("%s/ChangePassword error revoke other sessions: %s", userServicePrefixLog, err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if breached {
+		s.warnBreachedPassword(ctx, u.UserId)
+	}
+	return nil
+}
+
+// ChangeEmail отправляет ссылку подтверждения на новый адрес и уведомление на текущий.
+// Почта меняется только после перехода по ссылке (ConfirmEmailChange)
+func (s *userService) ChangeEmail(ctx context.Context, input UserChangeEmailInput) error {
+	u, err := s.user.FindById(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		log.Errorf("%s/ChangeEmail error find user by id: %s", userServicePrefixLog, err)
+		return err
+	}
+	if err = s.confirmPassword(ctx, "ChangeEmail", u, input.Password, input.IP); err != nil {
+		return err
+	}
+
+	email := normalizeEmail(input.NewEmail)
+	if email == u.Email {
+		return ErrSameEmail
+	}
+	if _, err = s.user.FindByEmail(ctx, email); err == nil {
+		return ErrUserAlreadyExists
+	} else if !errors.Is(err, pgerrs.ErrNotFound) {
+		log.Errorf("%s/ChangeEmail error find user by email: %s", userServicePrefixLog, err)
+		return err
+	}
+
+	token, hash, err := newUserToken()
+	if err != nil {
+		log.Errorf("%s/ChangeEmail error generate token: %s", userServicePrefixLog, err)
+		return err
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.token.RevokeAll(ctx, u.UserId, tokenPurposeEmailChange); err != nil {
+			log.Errorf("%s/ChangeEmail error revoke previous tokens: %s", userServicePrefixLog, err)
+			return err
+		}
+		err := s.token.Create(ctx, dbmodel.UserToken{
+			UserId:    u.UserId,
+			Purpose:   tokenPurposeEmailChange,
+			TokenHash: hash,
+			Payload:   email,
+			ExpiresAt: time.Now().Add(s.emailChange.TTL),
+		})
+		if err != nil {
+			log.Errorf("%s/ChangeEmail error create token: %s", userServicePrefixLog, err)
+			return err
+		}
+		if err = s.sendEmailChangeMessage(ctx, email, u.Locale, token); err != nil {
+			return err
+		}
+		return s.sendEmailChangeNotice(ctx, u.Email, u.Locale, email)
+	})
+}
+
+// ConfirmEmailChange меняет почту по токену из письма. Токен и адрес меняются в одной транзакции,
+// чтобы занятый к этому моменту адрес не сжигал ссылку
+func (s *userService) ConfirmEmailChange(ctx context.Context, token string) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		t, err := s.token.Use(ctx, tokenPurposeEmailChange, hashUserToken(token))
+		if err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrInvalidUserToken
+			}
+			log.Errorf("%s/ConfirmEmailChange error use token: %s", userServicePrefixLog, err)
+			return err
+		}
+		if err = s.user.UpdateEmail(ctx, t.UserId, t.Payload); err != nil {
+			if errors.Is(err, pgerrs.ErrAlreadyExist) {
+				return ErrUserAlreadyExists
+			}
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			log.Errorf("%s/ConfirmEmailChange error update email: %s", userServicePrefixLog, err)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *userService) sendEmailChangeMessage(ctx context.Context, to, locale, token string) error {
+	err := s.mail.send(ctx, mailKindEmailChange, to, locale, mailData{
+		Link:  tokenLink(s.emailChange.URL, token),
+		Token: token,
+		TTL:   s.emailChange.TTL,
+	})
+	if err != nil {
+		log.Errorf("%s/sendEmailChangeMessage error enqueue message: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+func (s *userService) sendEmailChangeNotice(ctx context.Context, to, locale, newEmail string) error {
+	err := s.mail.send(ctx, mailKindEmailChangeNotice, to, locale, mailData{
+		Time:     time.Now(),
+		NewEmail: newEmail,
+	})
+	if err != nil {
+		log.Errorf("%s/sendEmailChangeNotice error enqueue message: %s", userServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// checkPassword проверяет новый пароль по политике (ошибка содержит все нарушенные правила) и по набору
+// утекших паролей. breached сообщает о совпадении, если утекшие пароли разрешены с предупреждением
+func (s *userService) checkPassword(password, email string) (breached bool, err error) {
+	if err = s.policy.Check(password, email); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrWeakPassword, err)
+	}
+	if s.breachCheck.Checker == nil {
+		return false, nil
+	}
+	breached, err = s.breachCheck.Checker.Contains(password)
+	if err != nil {
+		// недоступный набор не должен блокировать регистрацию и смену пароля
+		log.Errorf("%s/checkPassword error check breached passwords: %s", userServicePrefixLog, err)
+		return false, nil
+	}
+	if breached && s.breachCheck.Reject {
+		return false, ErrBreachedPassword
+	}
+	return breached, nil
+}
+
+func (s *userService) warnBreachedPassword(ctx context.Context, userId string) {
+	log.Warnf("%s/warnBreachedPassword user %s set a password from a known data breach", userServicePrefixLog, userId)
+	err := s.event.Create(ctx, dbmodel.SecurityEvent{
+		UserId: userId,
+		Type:   securityEventBreachedPassword,
+	})
+	if err != nil {
+		log.Errorf("%s/warnBreachedPassword error create security event: %s", userServicePrefixLog, err)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
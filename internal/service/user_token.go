@@ -0,0 +1,51 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+)
+
+const (
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposePasswordReset     = "password_reset"
+	tokenPurposeEmailChange       = "email_change"
+	tokenPurposeMagicLink         = "magic_link"
+	// tokenPurposeMFAChallenge использованные mfa токены, хранится хэш jti
+	tokenPurposeMFAChallenge = "mfa_challenge"
+
+	userTokenBytes = 32
+)
+
+// newUserToken создает случайный одноразовый токен для письма и его хэш для хранения в бд.
+// Токен содержит 256 бит энтропии, поэтому медленный хэш для него не нужен
+func newUserToken() (token, hash string, err error) {
+	b := make([]byte, userTokenBytes)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashUserToken(token), nil
+}
+
+func hashUserToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// tokenLink добавляет токен в query параметр token ссылки из конфигурации. Без ссылки возвращается пустая строка,
+// и письмо показывает сам токен, а не ссылку
+func tokenLink(baseUrl, token string) string {
+	if baseUrl == "" {
+		return ""
+	}
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
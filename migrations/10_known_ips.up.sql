@@ -0,0 +1,8 @@
+create table if not exists known_ips
+(
+    user_id    varchar     not null references users (user_id) on delete cascade,
+    ip         varchar     not null,
+    first_seen timestamptz not null default now(),
+    last_seen  timestamptz not null default now(),
+    primary key (user_id, ip)
+);
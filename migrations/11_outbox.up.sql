@@ -0,0 +1,14 @@
+create table if not exists outbox
+(
+    id              bigserial primary key,
+    kind            varchar     not null,
+    recipient       varchar     not null,
+    message         text        not null,
+    attempts        int         not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_error      varchar,
+    created_at      timestamptz not null default now(),
+    dead_at         timestamptz
+);
+
+create index if not exists outbox_pending_idx on outbox (next_attempt_at) where dead_at is null;
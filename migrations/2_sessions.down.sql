@@ -0,0 +1,3 @@
+alter table users add column if not exists refresh_token varchar default '';
+
+drop table if exists sessions;
@@ -0,0 +1,17 @@
+create table if not exists sessions
+(
+    id            bigserial primary key,
+    session_id    varchar unique not null,
+    user_id       varchar        not null references users (user_id) on delete cascade,
+    refresh_token varchar        not null,
+    device        varchar        not null default '',
+    ip            varchar        not null default '',
+    user_agent    varchar        not null default '',
+    created_at    timestamptz    not null default now(),
+    refreshed_at  timestamptz    not null default now(),
+    expires_at    timestamptz    not null
+);
+
+create index if not exists sessions_user_id_idx on sessions (user_id);
+
+alter table users drop column if exists refresh_token;
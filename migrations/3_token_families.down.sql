@@ -0,0 +1,5 @@
+drop table if exists security_events;
+
+alter table sessions
+    drop column if exists generation,
+    drop column if exists revoked_at;
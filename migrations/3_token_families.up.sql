@@ -0,0 +1,16 @@
+alter table sessions
+    add column if not exists generation int not null default 0,
+    add column if not exists revoked_at timestamptz;
+
+create table if not exists security_events
+(
+    id         bigserial primary key,
+    user_id    varchar     not null,
+    session_id varchar     not null default '',
+    type       varchar     not null,
+    ip         varchar     not null default '',
+    details    varchar     not null default '',
+    created_at timestamptz not null default now()
+);
+
+create index if not exists security_events_user_id_idx on security_events (user_id);
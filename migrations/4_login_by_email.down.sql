@@ -0,0 +1,4 @@
+drop index if exists users_username_normalized_idx;
+drop index if exists users_email_normalized_idx;
+
+alter table users drop column if exists username;
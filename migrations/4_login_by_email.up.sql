@@ -0,0 +1,27 @@
+alter table users add column if not exists username varchar;
+
+-- аккаунты, почта которых совпадает после нормализации, нужно объединить или исправить вручную до миграции,
+-- иначе уникальный индекс по lower(email) не создать
+do
+$$
+    declare
+        conflicts text;
+    begin
+        select string_agg(format('%s: user_id %s', normalized, user_ids), '; ')
+        into conflicts
+        from (select lower(trim(email)) as normalized, string_agg(user_id, ', ' order by id) as user_ids
+              from users
+              group by lower(trim(email))
+              having count(*) > 1) as duplicates;
+
+        if conflicts is not null then
+            raise exception 'users with the same email after normalization (lower, trim): %', conflicts
+                using hint = 'merge or change the emails of these accounts and run the migration again';
+        end if;
+    end
+$$;
+
+update users set email = lower(trim(email));
+
+create unique index if not exists users_email_normalized_idx on users (lower(email));
+create unique index if not exists users_username_normalized_idx on users (lower(username)) where username is not null;
@@ -0,0 +1,14 @@
+alter table users add column if not exists email_verified boolean not null default false;
+
+create table if not exists user_tokens
+(
+    id         bigserial primary key,
+    user_id    varchar        not null references users (user_id) on delete cascade,
+    purpose    varchar        not null,
+    token_hash varchar unique not null,
+    expires_at timestamptz    not null,
+    used_at    timestamptz,
+    created_at timestamptz    not null default now()
+);
+
+create index if not exists user_tokens_user_id_purpose_idx on user_tokens (user_id, purpose, created_at);
@@ -0,0 +1 @@
+alter table user_tokens add column if not exists payload varchar not null default '';
@@ -0,0 +1,7 @@
+create table if not exists login_attempts
+(
+    key          varchar primary key,
+    failures     int         not null default 0,
+    last_failure timestamptz not null default now(),
+    locked_until timestamptz
+);
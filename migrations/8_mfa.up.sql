@@ -0,0 +1,18 @@
+create table if not exists user_totp
+(
+    user_id        varchar primary key references users (user_id) on delete cascade,
+    secret         varchar     not null,
+    confirmed_at   timestamptz,
+    last_used_step bigint      not null default 0,
+    created_at     timestamptz not null default now()
+);
+
+create table if not exists recovery_codes
+(
+    id         bigserial primary key,
+    user_id    varchar     not null references users (user_id) on delete cascade,
+    code_hash  varchar     not null,
+    used_at    timestamptz,
+    created_at timestamptz not null default now(),
+    unique (user_id, code_hash)
+);
@@ -0,0 +1,29 @@
+create table if not exists webauthn_credentials
+(
+    id               bigserial primary key,
+    user_id          varchar     not null references users (user_id) on delete cascade,
+    credential_id    bytea       not null unique,
+    public_key       bytea       not null,
+    attestation_type varchar     not null default '',
+    aaguid           bytea,
+    sign_count       bigint      not null default 0,
+    transports       varchar     not null default '',
+    backup_eligible  bool        not null default false,
+    backup_state     bool        not null default false,
+    name             varchar     not null default '',
+    second_factor    bool        not null default false,
+    created_at       timestamptz not null default now(),
+    last_used_at     timestamptz
+);
+
+create index if not exists webauthn_credentials_user_id_idx on webauthn_credentials (user_id);
+
+create table if not exists webauthn_challenges
+(
+    id           varchar primary key,
+    user_id      varchar     not null default '',
+    purpose      varchar     not null,
+    session_data varchar     not null,
+    expires_at   timestamptz not null,
+    created_at   timestamptz not null default now()
+);
@@ -0,0 +1,150 @@
+package breach
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	bloomMagic = "HIBPBLM1"
+	// bloomMaxK с запасом больше оптимального k даже для доли ложных срабатываний 1e-12
+	bloomMaxK = 64
+)
+
+var ErrInvalidBloom = errors.New("invalid bloom filter file")
+
+// Bloom фильтр Блума по SHA-1 хэшам. SHA-1 уже равномерно распределен, поэтому позиции битов берутся
+// двойным хэшированием из первых 16 байт хэша. Ложноположительные срабатывания возможны, ложноотрицательные нет
+type Bloom struct {
+	bits []uint64
+	m    uint64
+	k    uint32
+}
+
+// NewBloom рассчитывает размер фильтра для n элементов с долей ложных срабатываний p
+func NewBloom(n uint64, p float64) (*Bloom, error) {
+	if n == 0 || p <= 0 || p >= 1 {
+		return nil, fmt.Errorf("invalid bloom filter params n=%d p=%g", n, p)
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	if k > bloomMaxK {
+		return nil, fmt.Errorf("bloom filter false positive rate %g is too low", p)
+	}
+	return newBloom(m, k), nil
+}
+
+func newBloom(m uint64, k uint32) *Bloom {
+	return &Bloom{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add добавляет SHA-1 хэш в hex виде
+func (b *Bloom) Add(hash string) error {
+	h1, h2, err := bloomHashes(hash)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < uint64(b.k); i++ {
+		idx := (h1 + i*h2) % b.m
+		b.bits[idx/64] |= 1 << (idx % 64)
+	}
+	return nil
+}
+
+func (b *Bloom) test(hash string) (bool, error) {
+	h1, h2, err := bloomHashes(hash)
+	if err != nil {
+		return false, err
+	}
+	for i := uint64(0); i < uint64(b.k); i++ {
+		idx := (h1 + i*h2) % b.m
+		if b.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (b *Bloom) Contains(password string) (bool, error) {
+	return b.test(Hash(password))
+}
+
+// WriteTo сохраняет фильтр: magic, m, k, затем биты (все числа little endian)
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, len(bloomMagic)+12)
+	copy(header, bloomMagic)
+	binary.LittleEndian.PutUint64(header[len(bloomMagic):], b.m)
+	binary.LittleEndian.PutUint32(header[len(bloomMagic)+8:], b.k)
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 8)
+	for _, word := range b.bits {
+		binary.LittleEndian.PutUint64(buf, word)
+		if _, err := bw.Write(buf); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(header) + 8*len(b.bits)), bw.Flush()
+}
+
+// LoadBloom загружает фильтр, созданный cmd/breachfilter, целиком в память
+func LoadBloom(path string) (*Bloom, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	r := bufio.NewReader(f)
+
+	header := make([]byte, len(bloomMagic)+12)
+	if _, err = io.ReadFull(r, header); err != nil || string(header[:len(bloomMagic)]) != bloomMagic {
+		return nil, ErrInvalidBloom
+	}
+	m := binary.LittleEndian.Uint64(header[len(bloomMagic):])
+	k := binary.LittleEndian.Uint32(header[len(bloomMagic)+8:])
+	if m == 0 || k == 0 || k > bloomMaxK {
+		return nil, ErrInvalidBloom
+	}
+	// размер из заголовка сверяется с файлом до выделения памяти, чтобы поврежденный файл не занял всю память
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	words := m/64 + min(m%64, 1)
+	if uint64(info.Size()) != uint64(len(header))+words*8 {
+		return nil, ErrInvalidBloom
+	}
+
+	b := newBloom(m, k)
+	buf := make([]byte, 8)
+	for i := range b.bits {
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, ErrInvalidBloom
+		}
+		b.bits[i] = binary.LittleEndian.Uint64(buf)
+	}
+	return b, nil
+}
+
+func bloomHashes(hash string) (uint64, uint64, error) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) < 16 {
+		return 0, 0, fmt.Errorf("invalid sha1 hash %q", hash)
+	}
+	h1 := binary.LittleEndian.Uint64(raw[:8])
+	// нечетный шаг не дает позициям зациклиться раньше времени
+	h2 := binary.LittleEndian.Uint64(raw[8:16]) | 1
+	return h1, h2, nil
+}
@@ -0,0 +1,36 @@
+package breach
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Checker проверяет пароль по локальному набору утекших паролей (формат Have I Been Pwned, SHA-1)
+type Checker interface {
+	Contains(password string) (bool, error)
+}
+
+// Hash SHA-1 пароля в верхнем регистре, как в дампах HIBP
+func Hash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// ParseLine разбирает строку дампа "HASH:COUNT" (или только "HASH") и возвращает хэш в верхнем регистре
+func ParseLine(line string) (hash string, count int, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", 0, false
+	}
+	hash, rawCount, found := strings.Cut(line, ":")
+	count = 1
+	if found {
+		var err error
+		if count, err = strconv.Atoi(strings.TrimSpace(rawCount)); err != nil {
+			return "", 0, false
+		}
+	}
+	return strings.ToUpper(hash), count, true
+}
@@ -0,0 +1,62 @@
+package breach
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const prefixLength = 5
+
+// Ranges набор файлов диапазонов HIBP: каждый файл назван первыми 5 символами SHA-1 (с расширением .txt или без)
+// и содержит строки "SUFFIX:COUNT" с оставшимися 35 символами хэша. Читается только файл нужного префикса
+type Ranges struct {
+	dir      string
+	minCount int
+}
+
+// NewRanges minCount отсекает пароли, встречавшиеся в утечках реже указанного числа раз
+func NewRanges(dir string, minCount int) (*Ranges, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breach ranges %s is not a directory", dir)
+	}
+	return &Ranges{dir: dir, minCount: minCount}, nil
+}
+
+func (r *Ranges) Contains(password string) (bool, error) {
+	hash := Hash(password)
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	f, err := r.open(prefix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h, count, ok := ParseLine(scanner.Text())
+		if ok && h == suffix {
+			return count >= r.minCount, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (r *Ranges) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(r.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(r.dir, prefix))
+	}
+	return f, err
+}
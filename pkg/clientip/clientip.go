@@ -0,0 +1,157 @@
+package clientip
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+)
+
+var ErrInvalidRemoteAddr = errors.New("invalid remote addr")
+
+// Resolver определяет ip клиента. Заголовки читаются, только если запрос пришел от доверенного прокси,
+// а цепочка адресов проходится справа налево до первого недоверенного адреса: левую часть цепочки клиент
+// может подделать, правую добавили наши прокси
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+func New(opts ...Option) (*Resolver, error) {
+	r := &Resolver{
+		header: HeaderXForwardedFor,
+	}
+	for _, option := range opts {
+		option(r)
+	}
+
+	r.header = http.CanonicalHeaderKey(strings.TrimSpace(r.header))
+	switch r.header {
+	case HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP:
+	default:
+		return nil, fmt.Errorf("unsupported client ip header %q", r.header)
+	}
+	return r, nil
+}
+
+// ClientIP ip клиента для запроса. Без доверенных прокси это всегда адрес соединения
+func (r *Resolver) ClientIP(req *http.Request) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, ErrInvalidRemoteAddr
+	}
+	ip := addrPort.Addr().Unmap()
+	if !r.isTrusted(ip) {
+		return ip, nil
+	}
+
+	chain := r.chain(req.Header)
+	for i := len(chain) - 1; i >= 0 && r.isTrusted(ip); i-- {
+		next, ok := parseNode(chain[i])
+		if !ok {
+			// адрес, который прокси не смог указать (unknown, обфусцированный), дальше цепочке не доверяем
+			break
+		}
+		ip = next
+	}
+	return ip, nil
+}
+
+// chain адреса из заголовка, от клиента к последнему прокси
+func (r *Resolver) chain(header http.Header) []string {
+	values := header.Values(r.header)
+	if len(values) == 0 {
+		return nil
+	}
+	switch r.header {
+	case HeaderForwarded:
+		return forwardedFor(values)
+	case HeaderXRealIP:
+		// X-Real-IP выставляет сам прокси, поэтому берется только последнее значение
+		return values[len(values)-1:]
+	default:
+		return splitList(values)
+	}
+}
+
+func (r *Resolver) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes разбирает список CIDR, одиночный адрес считается сетью из одного адреса
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return list
+}
+
+// forwardedFor значения параметра for из заголовков Forwarded (RFC 7239).
+// Элемент без for тоже попадает в цепочку, чтобы на нем прервать проход
+func forwardedFor(values []string) []string {
+	var list []string
+	for _, element := range splitList(values) {
+		var node string
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "for") {
+				node = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+		list = append(list, node)
+	}
+	return list
+}
+
+// parseNode адрес узла в форматах ip, ip:port, [ipv6] и [ipv6]:port
+func parseNode(node string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,260 @@
+package clientip
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func newTestResolver(t *testing.T, header string, trusted ...string) *Resolver {
+	t.Helper()
+	prefixes, err := ParsePrefixes(trusted)
+	if err != nil {
+		t.Fatalf("ParsePrefixes error: %s", err)
+	}
+	r, err := New(TrustedProxies(prefixes...), Header(header))
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	return r
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:   "untrusted remote ignores headers",
+			header: HeaderXForwardedFor,
+			remote: "203.0.113.7:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"198.51.100.1"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name:   "trusted remote without header",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			want:   "10.0.0.1",
+		},
+		{
+			name:   "single proxy",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "chain through trusted proxies",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"198.51.100.1, 192.168.1.1, 10.1.2.3"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "spoofed left part stops at first untrusted",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"1.2.3.4, 198.51.100.1, 10.1.2.3"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "chain split across header lines",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"1.2.3.4, 198.51.100.1", "10.1.2.3"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "all hops trusted returns leftmost",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"10.0.0.5, 10.0.0.6"},
+			},
+			want: "10.0.0.5",
+		},
+		{
+			name:   "garbage entry stops the walk",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"198.51.100.1, garbage, 10.1.2.3"},
+			},
+			want: "10.1.2.3",
+		},
+		{
+			name:   "ipv4 mapped ipv6 remote",
+			header: HeaderXForwardedFor,
+			remote: "[::ffff:10.0.0.1]:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "ipv6 proxy and client with port",
+			header: HeaderXForwardedFor,
+			remote: "[fd00::1]:4000",
+			headers: map[string][]string{
+				HeaderXForwardedFor: {"[2001:db8::1]:5000"},
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:   "not configured header is ignored",
+			header: HeaderXForwardedFor,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXRealIP:   {"198.51.100.1"},
+				HeaderForwarded: {"for=198.51.100.2"},
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name:   "x-real-ip uses the last value",
+			header: HeaderXRealIP,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderXRealIP: {"1.2.3.4", "198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "forwarded chain",
+			header: HeaderForwarded,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderForwarded: {`for=1.2.3.4, for=198.51.100.1;proto=https, for=10.1.2.3;by=10.0.0.1`},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:   "forwarded quoted ipv6 with port",
+			header: HeaderForwarded,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderForwarded: {`For="[2001:db8::1]:4711"`},
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:   "forwarded unknown stops the walk",
+			header: HeaderForwarded,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderForwarded: {"for=198.51.100.1, for=unknown"},
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name:   "forwarded element without for stops the walk",
+			header: HeaderForwarded,
+			remote: "10.0.0.1:4000",
+			headers: map[string][]string{
+				HeaderForwarded: {"for=198.51.100.1, proto=https"},
+			},
+			want: "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestResolver(t, tt.header, trusted...)
+			req := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			for k, values := range tt.headers {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+			got, err := r.ClientIP(req)
+			if err != nil {
+				t.Fatalf("ClientIP error: %s", err)
+			}
+			if got != netip.MustParseAddr(tt.want) {
+				t.Errorf("ClientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPInvalidRemote(t *testing.T) {
+	r := newTestResolver(t, HeaderXForwardedFor)
+	if _, err := r.ClientIP(&http.Request{RemoteAddr: "pipe", Header: http.Header{}}); err != ErrInvalidRemoteAddr {
+		t.Errorf("ClientIP() error = %v, want %v", err, ErrInvalidRemoteAddr)
+	}
+}
+
+func TestNewHeader(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"x-forwarded-for", HeaderXForwardedFor, false},
+		{" X-Real-IP ", HeaderXRealIP, false},
+		{"forwarded", HeaderForwarded, false},
+		{"CF-Connecting-IP", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r, err := New(Header(tt.header))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New(%q) error = %v, want error %t", tt.header, err, tt.wantErr)
+			}
+			if err == nil && r.header != tt.want {
+				t.Errorf("New(%q) header = %q, want %q", tt.header, r.header, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []string
+		want    []string
+		wantErr bool
+	}{
+		{"cidr is masked", []string{"10.1.2.3/8"}, []string{"10.0.0.0/8"}, false},
+		{"single ipv4", []string{"192.168.1.1"}, []string{"192.168.1.1/32"}, false},
+		{"single ipv6", []string{"::1"}, []string{"::1/128"}, false},
+		{"mapped ipv4 is unmapped", []string{"::ffff:10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{"blanks are skipped", []string{" ", " 10.0.0.0/8 "}, []string{"10.0.0.0/8"}, false},
+		{"invalid address", []string{"10.0.0"}, nil, true},
+		{"invalid cidr", []string{"10.0.0.0/33"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrefixes(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrefixes(%q) error = %v, want error %t", tt.list, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParsePrefixes(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+			for i, prefix := range got {
+				if prefix.String() != tt.want[i] {
+					t.Errorf("ParsePrefixes(%q)[%d] = %s, want %s", tt.list, i, prefix, tt.want[i])
+				}
+			}
+		})
+	}
+}
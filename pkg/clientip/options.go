@@ -0,0 +1,20 @@
+package clientip
+
+import "net/netip"
+
+type Option func(r *Resolver)
+
+// TrustedProxies адреса прокси, заголовкам которых можно доверять
+func TrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(r *Resolver) {
+		r.trusted = prefixes
+	}
+}
+
+// Header заголовок с цепочкой адресов. Остальные заголовки игнорируются: прокси дополняет только свой,
+// а чужой клиент может прислать любым
+func Header(header string) Option {
+	return func(r *Resolver) {
+		r.header = header
+	}
+}
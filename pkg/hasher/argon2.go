@@ -0,0 +1,86 @@
+package hasher
+
+import (
+	"crypto/subtle"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"strconv"
+)
+
+// параметры по рекомендации OWASP для argon2id
+var defaultArgon2Params = argon2Params{
+	memory:      64 * 1024,
+	iterations:  3,
+	parallelism: 2,
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func (p argon2Params) validate() error {
+	if p.memory < 8*uint32(p.parallelism) || p.iterations < 1 || p.parallelism < 1 {
+		return errors.New("invalid argon2id params")
+	}
+	return nil
+}
+
+type argon2Algorithm struct {
+	params argon2Params
+}
+
+func (a *argon2Algorithm) hash(peppered []byte, pepperVersion int) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(peppered, salt, a.params.iterations, a.params.memory, a.params.parallelism, keyLength)
+
+	return phcHash{
+		id:      Argon2id,
+		version: strconv.Itoa(argon2.Version),
+		params: withPepperParam(map[string]string{
+			"m": strconv.FormatUint(uint64(a.params.memory), 10),
+			"t": strconv.FormatUint(uint64(a.params.iterations), 10),
+			"p": strconv.FormatUint(uint64(a.params.parallelism), 10),
+		}, pepperVersion),
+		salt: salt,
+		hash: key,
+	}.String(), nil
+}
+
+func (a *argon2Algorithm) verify(peppered []byte, hashedPassword string) bool {
+	p, params, err := parseArgon2(hashedPassword)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey(peppered, p.salt, params.iterations, params.memory, params.parallelism, uint32(len(p.hash)))
+	return subtle.ConstantTimeCompare(key, p.hash) == 1
+}
+
+func (a *argon2Algorithm) needsRehash(hashedPassword string) bool {
+	_, params, err := parseArgon2(hashedPassword)
+	return err != nil || params != a.params
+}
+
+func parseArgon2(hashedPassword string) (phcHash, argon2Params, error) {
+	p, err := parsePHC(hashedPassword)
+	if err != nil || p.id != Argon2id || p.version != strconv.Itoa(argon2.Version) {
+		return phcHash{}, argon2Params{}, errMalformedHash
+	}
+	m, err := p.intParam("m")
+	if err != nil {
+		return phcHash{}, argon2Params{}, err
+	}
+	t, err := p.intParam("t")
+	if err != nil {
+		return phcHash{}, argon2Params{}, err
+	}
+	par, err := p.intParam("p")
+	if err != nil || par > 255 {
+		return phcHash{}, argon2Params{}, errMalformedHash
+	}
+	return p, argon2Params{memory: uint32(m), iterations: uint32(t), parallelism: uint8(par)}, nil
+}
@@ -0,0 +1,50 @@
+package hasher
+
+import (
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"strings"
+)
+
+const defaultBcryptCost = 12
+
+func validateBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %d", cost)
+	}
+	return nil
+}
+
+type bcryptAlgorithm struct {
+	cost int
+}
+
+func (a *bcryptAlgorithm) hash(peppered []byte, pepperVersion int) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword(peppered, a.cost)
+	if err != nil {
+		return "", err
+	}
+	if pepperVersion == 0 {
+		return string(hashed), nil
+	}
+	return bcryptPepperPrefix + strconv.Itoa(pepperVersion) + string(hashed), nil
+}
+
+func (a *bcryptAlgorithm) verify(peppered []byte, hashedPassword string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(bcryptNative(hashedPassword)), peppered) == nil
+}
+
+func (a *bcryptAlgorithm) needsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(bcryptNative(hashedPassword)))
+	return err != nil || cost != a.cost
+}
+
+// bcryptNative отрезает префикс с версией секрета
+func bcryptNative(hashedPassword string) string {
+	if !strings.HasPrefix(hashedPassword, bcryptPepperPrefix) {
+		return hashedPassword
+	}
+	_, native, _ := strings.Cut(strings.TrimPrefix(hashedPassword, bcryptPepperPrefix), "$")
+	return "$" + native
+}
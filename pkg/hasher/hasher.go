@@ -1,35 +1,155 @@
 package hasher
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"github.com/google/uuid"
 	"strings"
 )
 
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+	Scrypt   = "scrypt"
+	// legacy sha256(salt + secret + password), поддерживается только проверка
+	legacy = "sha256"
+)
+
+// MaxPasswordLength максимальная длина пароля в байтах. Алгоритмы получают пароль через HMAC и сами
+// длину не ограничивают, предел защищает от затрат на хэширование очень длинных строк
+const MaxPasswordLength = 1024
+
+var ErrPasswordTooLong = fmt.Errorf("password is longer than %d bytes", MaxPasswordLength)
+
 type Hasher interface {
-	Hash(password string) string
+	Hash(password string) (string, error)
 	Verify(password, hashedPassword string) bool
+	// NeedsRehash сообщает, что хэш создан устаревшим алгоритмом или с другими параметрами
+	NeedsRehash(hashedPassword string) bool
+}
+
+// algorithm реализация конкретного алгоритма. Пароль приходит уже смешанным с секретом (pepper),
+// версия секрета сохраняется в хэше, чтобы при проверке выбрать нужный
+type algorithm interface {
+	hash(peppered []byte, pepperVersion int) (string, error)
+	verify(peppered []byte, hashedPassword string) bool
+	needsRehash(hashedPassword string) bool
 }
 
 type hasher struct {
-	secret string
+	// peppers секреты по версиям, версия 0 - HASHER_SECRET, которым созданы хэши без версии
+	peppers       map[int]string
+	pepperVersion int
+	algorithm     string
+	argon2        argon2Params
+	bcryptCost    int
+	scrypt        scryptParams
+}
+
+func NewHasher(secret string, opts ...Option) (Hasher, error) {
+	h := &hasher{
+		peppers:    map[int]string{0: secret},
+		algorithm:  Argon2id,
+		argon2:     defaultArgon2Params,
+		bcryptCost: defaultBcryptCost,
+		scrypt:     defaultScryptParams,
+	}
+	for _, option := range opts {
+		option(h)
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
-func NewHasher(secret string) Hasher {
-	return &hasher{secret: secret}
+func (h *hasher) validate() error {
+	if _, ok := h.peppers[h.pepperVersion]; !ok {
+		return fmt.Errorf("pepper version %d is not configured", h.pepperVersion)
+	}
+	switch h.algorithm {
+	case Argon2id:
+		return h.argon2.validate()
+	case Bcrypt:
+		return validateBcryptCost(h.bcryptCost)
+	case Scrypt:
+		return h.scrypt.validate()
+	default:
+		return fmt.Errorf("unsupported hash algorithm %q", h.algorithm)
+	}
 }
 
-func (h *hasher) Hash(password string) string {
-	salt := hex.EncodeToString([]byte(uuid.NewString()))
-	res := sha256.Sum256([]byte(salt + h.secret + password))
-	return fmt.Sprintf("%x:%s", res, salt)
+func (h *hasher) Hash(password string) (string, error) {
+	if len(password) > MaxPasswordLength {
+		return "", ErrPasswordTooLong
+	}
+	return h.current().hash(pepper(h.peppers[h.pepperVersion], password), h.pepperVersion)
 }
 
 func (h *hasher) Verify(password, hashedPassword string) bool {
-	data := strings.Split(hashedPassword, ":")
-	key, salt := data[0], data[1]
-	res := sha256.Sum256([]byte(salt + h.secret + password))
-	return key == fmt.Sprintf("%x", res)
+	if len(password) > MaxPasswordLength {
+		return false
+	}
+	name := identify(hashedPassword)
+	if name == legacy {
+		return verifyLegacy(h.peppers[0], password, hashedPassword)
+	}
+	version, err := pepperVersion(hashedPassword)
+	if err != nil {
+		return false
+	}
+	secret, ok := h.peppers[version]
+	if !ok {
+		return false
+	}
+	return h.byName(name).verify(pepper(secret, password), hashedPassword)
+}
+
+func (h *hasher) NeedsRehash(hashedPassword string) bool {
+	if identify(hashedPassword) != h.algorithm {
+		return true
+	}
+	if version, err := pepperVersion(hashedPassword); err != nil || version != h.pepperVersion {
+		return true
+	}
+	return h.current().needsRehash(hashedPassword)
+}
+
+func (h *hasher) current() algorithm {
+	return h.byName(h.algorithm)
+}
+
+func (h *hasher) byName(name string) algorithm {
+	switch name {
+	case Bcrypt:
+		return &bcryptAlgorithm{cost: h.bcryptCost}
+	case Scrypt:
+		return &scryptAlgorithm{params: h.scrypt}
+	default:
+		return &argon2Algorithm{params: h.argon2}
+	}
+}
+
+// pepper смешивает пароль с секретом через HMAC-SHA256. Результат фиксированной длины,
+// поэтому ограничение bcrypt в 72 байта не обрезает длинные пароли
+func pepper(secret, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(password))
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// identify определяет алгоритм по префиксу хэша в формате PHC (или modular crypt для bcrypt)
+func identify(hashedPassword string) string {
+	switch {
+	case strings.HasPrefix(hashedPassword, "$"+Argon2id+"$"):
+		return Argon2id
+	case strings.HasPrefix(hashedPassword, "$"+Scrypt+"$"):
+		return Scrypt
+	case strings.HasPrefix(hashedPassword, bcryptPepperPrefix),
+		strings.HasPrefix(hashedPassword, "$2a$"), strings.HasPrefix(hashedPassword, "$2b$"), strings.HasPrefix(hashedPassword, "$2y$"):
+		return Bcrypt
+	default:
+		return legacy
+	}
 }
@@ -0,0 +1,199 @@
+package hasher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testSecret = "secret"
+
+// параметры минимальной стоимости, чтобы тесты не тратили время на хэширование
+var testOptions = []Option{
+	Argon2Params(64, 1, 1),
+	BcryptCost(4),
+	ScryptParams(16, 1, 1),
+}
+
+func newTestHasher(t *testing.T, opts ...Option) Hasher {
+	t.Helper()
+	h, err := NewHasher(testSecret, append(append([]Option{}, testOptions...), opts...)...)
+	if err != nil {
+		t.Fatalf("NewHasher error: %s", err)
+	}
+	return h
+}
+
+func TestHashVerify(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{Argon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{Bcrypt, "$2a$04$"},
+		{Scrypt, "$scrypt$ln=4,r=1,p=1$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			h := newTestHasher(t, Algorithm(tt.algorithm))
+			hashed, err := h.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash error: %s", err)
+			}
+			if !strings.HasPrefix(hashed, tt.prefix) {
+				t.Errorf("Hash() = %q, want prefix %q", hashed, tt.prefix)
+			}
+			if !h.Verify("password", hashed) {
+				t.Error("Verify() = false for the correct password")
+			}
+			if h.Verify("Password", hashed) {
+				t.Error("Verify() = true for a wrong password")
+			}
+			if h.NeedsRehash(hashed) {
+				t.Error("NeedsRehash() = true for a hash with current params")
+			}
+
+			other, err := NewHasher("other secret", append(testOptions, Algorithm(tt.algorithm))...)
+			if err != nil {
+				t.Fatalf("NewHasher error: %s", err)
+			}
+			if other.Verify("password", hashed) {
+				t.Error("Verify() = true with another secret")
+			}
+		})
+	}
+}
+
+func TestHashSalted(t *testing.T) {
+	h := newTestHasher(t)
+	first, err := h.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash error: %s", err)
+	}
+	second, err := h.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash error: %s", err)
+	}
+	if first == second {
+		t.Error("Hash() returned the same hash twice, salt is not random")
+	}
+}
+
+func TestPasswordTooLong(t *testing.T) {
+	h := newTestHasher(t)
+	long := strings.Repeat("a", MaxPasswordLength+1)
+	if _, err := h.Hash(long); err != ErrPasswordTooLong {
+		t.Errorf("Hash() error = %v, want %v", err, ErrPasswordTooLong)
+	}
+	hashed, err := h.Hash(long[:MaxPasswordLength])
+	if err != nil {
+		t.Fatalf("Hash error: %s", err)
+	}
+	if h.Verify(long, hashed) {
+		t.Error("Verify() = true for a password longer than MaxPasswordLength")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	h := newTestHasher(t)
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"truncated argon2id", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{"argon2id wrong version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"},
+		{"argon2id parallelism overflow", "$argon2id$v=19$m=64,t=1,p=256$c2FsdA$a2V5"},
+		{"argon2id missing param", "$argon2id$v=19$m=64,p=1$c2FsdA$a2V5"},
+		{"scrypt huge n", "$scrypt$ln=31,r=1,p=1$c2FsdA$a2V5"},
+		{"scrypt zero r", "$scrypt$ln=4,r=0,p=1$c2FsdA$a2V5"},
+		{"bcrypt truncated", "$2a$04$abc"},
+		{"unknown pepper version", "$argon2id$v=19$m=64,t=1,p=1,pv=9$c2FsdA$a2V5"},
+		{"negative pepper version", "$argon2id$v=19$m=64,t=1,p=1,pv=-1$c2FsdA$a2V5"},
+		{"legacy without salt", "abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if h.Verify("password", tt.hash) {
+				t.Errorf("Verify(%q) = true", tt.hash)
+			}
+			if !h.NeedsRehash(tt.hash) {
+				t.Errorf("NeedsRehash(%q) = false", tt.hash)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hashWith := func(opts ...Option) string {
+		h := newTestHasher(t, opts...)
+		hashed, err := h.Hash("password")
+		if err != nil {
+			t.Fatalf("Hash error: %s", err)
+		}
+		return hashed
+	}
+	tests := []struct {
+		name   string
+		hashed string
+		opts   []Option
+		want   bool
+	}{
+		{"same params", hashWith(), nil, false},
+		{"other algorithm", hashWith(Algorithm(Bcrypt)), nil, true},
+		{"argon2id memory changed", hashWith(), []Option{Argon2Params(128, 1, 1)}, true},
+		{"argon2id iterations changed", hashWith(), []Option{Argon2Params(64, 2, 1)}, true},
+		{"bcrypt cost changed", hashWith(Algorithm(Bcrypt)), []Option{Algorithm(Bcrypt), BcryptCost(5)}, true},
+		{"scrypt n changed", hashWith(Algorithm(Scrypt)), []Option{Algorithm(Scrypt), ScryptParams(32, 1, 1)}, true},
+		{"legacy", legacyHash(testSecret, "password", "salt"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHasher(t, tt.opts...)
+			if got := h.NeedsRehash(tt.hashed); got != tt.want {
+				t.Errorf("NeedsRehash() = %t, want %t", got, tt.want)
+			}
+			// смена параметров не мешает проверить пароль по старому хэшу
+			if !h.Verify("password", tt.hashed) {
+				t.Error("Verify() = false for a hash with previous params")
+			}
+		})
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	h := newTestHasher(t)
+	hashed := legacyHash(testSecret, "password", "salt")
+	if !h.Verify("password", hashed) {
+		t.Error("Verify() = false for a legacy hash")
+	}
+	if h.Verify("other", hashed) {
+		t.Error("Verify() = true for a wrong password")
+	}
+}
+
+func TestNewHasherInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"unknown algorithm", []Option{Algorithm("md5")}},
+		{"argon2id zero iterations", []Option{Argon2Params(64, 0, 1)}},
+		{"argon2id memory below 8 per thread", []Option{Argon2Params(8, 1, 2)}},
+		{"bcrypt cost too low", []Option{Algorithm(Bcrypt), BcryptCost(3)}},
+		{"scrypt n not power of two", []Option{Algorithm(Scrypt), ScryptParams(100, 1, 1)}},
+		{"pepper version not configured", []Option{Peppers(2, map[int]string{1: "one"})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHasher(testSecret, tt.opts...); err == nil {
+				t.Error("NewHasher() error = nil")
+			}
+		})
+	}
+}
+
+func legacyHash(secret, password, salt string) string {
+	return fmt.Sprintf("%x:%s", sha256.Sum256([]byte(salt+secret+password)), salt)
+}
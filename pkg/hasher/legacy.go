@@ -0,0 +1,19 @@
+package hasher
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// verifyLegacy проверяет хэши первой версии сервиса в формате hex(sha256(salt + secret + password)):salt.
+// Новые хэши в этом формате не создаются, после успешного входа пароль перехэшируется
+func verifyLegacy(secret, password, hashedPassword string) bool {
+	key, salt, ok := strings.Cut(hashedPassword, ":")
+	if !ok || key == "" || salt == "" {
+		return false
+	}
+	res := fmt.Sprintf("%x", sha256.Sum256([]byte(salt+secret+password)))
+	return subtle.ConstantTimeCompare([]byte(key), []byte(res)) == 1
+}
@@ -0,0 +1,48 @@
+package hasher
+
+type Option func(h *hasher)
+
+func Algorithm(name string) Option {
+	return func(h *hasher) {
+		h.algorithm = name
+	}
+}
+
+// Argon2Params задает параметры argon2id: память в KiB, число итераций и потоков
+func Argon2Params(memory, iterations uint32, parallelism uint8) Option {
+	return func(h *hasher) {
+		h.argon2 = argon2Params{
+			memory:      memory,
+			iterations:  iterations,
+			parallelism: parallelism,
+		}
+	}
+}
+
+func BcryptCost(cost int) Option {
+	return func(h *hasher) {
+		h.bcryptCost = cost
+	}
+}
+
+// ScryptParams задает параметры scrypt, n должен быть степенью двойки
+func ScryptParams(n, r, p int) Option {
+	return func(h *hasher) {
+		h.scrypt = scryptParams{
+			n: n,
+			r: r,
+			p: p,
+		}
+	}
+}
+
+// Peppers добавляет секреты по версиям (версия 0 - основной секрет хэшера) и выбирает текущую версию
+// для новых хэшей. Хэши со старыми версиями проверяются своим секретом и требуют перехэширования
+func Peppers(current int, peppers map[int]string) Option {
+	return func(h *hasher) {
+		for version, secret := range peppers {
+			h.peppers[version] = secret
+		}
+		h.pepperVersion = current
+	}
+}
@@ -0,0 +1,53 @@
+package hasher
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// pepperParam параметр PHC строки с версией секрета. Отсутствует у хэшей, созданных секретом версии 0
+	pepperParam = "pv"
+	// bcrypt хэш не имеет места для параметров, поэтому версия секрета дописывается префиксом: $bcrypt$pv=1$2a$12$...
+	bcryptPepperPrefix = "$bcrypt$" + pepperParam + "="
+)
+
+// pepperVersion возвращает версию секрета, которым создан хэш
+func pepperVersion(hashedPassword string) (int, error) {
+	var raw string
+	switch identify(hashedPassword) {
+	case Bcrypt:
+		if !strings.HasPrefix(hashedPassword, bcryptPepperPrefix) {
+			return 0, nil
+		}
+		var ok bool
+		raw, _, ok = strings.Cut(strings.TrimPrefix(hashedPassword, bcryptPepperPrefix), "$")
+		if !ok {
+			return 0, errMalformedHash
+		}
+	case Argon2id, Scrypt:
+		p, err := parsePHC(hashedPassword)
+		if err != nil {
+			return 0, err
+		}
+		var ok bool
+		if raw, ok = p.params[pepperParam]; !ok {
+			return 0, nil
+		}
+	default:
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 0 {
+		return 0, errMalformedHash
+	}
+	return version, nil
+}
+
+func withPepperParam(params map[string]string, version int) map[string]string {
+	if version != 0 {
+		params[pepperParam] = strconv.Itoa(version)
+	}
+	return params
+}
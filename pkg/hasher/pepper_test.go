@@ -0,0 +1,102 @@
+package hasher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPepperVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		want    int
+		wantErr bool
+	}{
+		{"argon2id without version", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5", 0, false},
+		{"argon2id with version", "$argon2id$v=19$m=64,t=1,p=1,pv=3$c2FsdA$a2V5", 3, false},
+		{"scrypt with version", "$scrypt$ln=4,r=1,p=1,pv=2$c2FsdA$a2V5", 2, false},
+		{"bcrypt without prefix", "$2a$04$abcdefghijklmnopqrstuv", 0, false},
+		{"bcrypt with prefix", "$bcrypt$pv=5$2a$04$abcdefghijklmnopqrstuv", 5, false},
+		{"bcrypt prefix without hash", "$bcrypt$pv=5", 0, true},
+		{"legacy", "abcdef:salt", 0, false},
+		{"not a number", "$argon2id$v=19$m=64,t=1,p=1,pv=x$c2FsdA$a2V5", 0, true},
+		{"negative", "$scrypt$ln=4,r=1,p=1,pv=-2$c2FsdA$a2V5", 0, true},
+		{"malformed phc", "$argon2id$v=19$m=64", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pepperVersion(tt.hash)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("pepperVersion(%q) = %d, %v, want %d, error %t", tt.hash, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPepperRotation(t *testing.T) {
+	peppers := map[int]string{1: "first", 2: "second"}
+	tests := []struct {
+		algorithm string
+		marker    string
+	}{
+		{Argon2id, ",pv=2$"},
+		{Bcrypt, "$bcrypt$pv=2$"},
+		{Scrypt, ",pv=2$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			old := newTestHasher(t, Algorithm(tt.algorithm), Peppers(1, peppers))
+			current := newTestHasher(t, Algorithm(tt.algorithm), Peppers(2, peppers))
+			base := newTestHasher(t, Algorithm(tt.algorithm))
+
+			hashed, err := current.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash error: %s", err)
+			}
+			if !strings.Contains(hashed, tt.marker) {
+				t.Errorf("Hash() = %q, want pepper version marker %q", hashed, tt.marker)
+			}
+			if !current.Verify("password", hashed) || current.NeedsRehash(hashed) {
+				t.Error("hash with the current pepper must verify without rehash")
+			}
+
+			previous, err := old.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash error: %s", err)
+			}
+			if !current.Verify("password", previous) {
+				t.Error("Verify() = false for a hash with a previous pepper version")
+			}
+			if !current.NeedsRehash(previous) {
+				t.Error("NeedsRehash() = false for a hash with a previous pepper version")
+			}
+
+			unversioned, err := base.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash error: %s", err)
+			}
+			if !current.Verify("password", unversioned) {
+				t.Error("Verify() = false for a hash made with the main secret")
+			}
+			if !current.NeedsRehash(unversioned) {
+				t.Error("NeedsRehash() = false for a hash made with the main secret")
+			}
+
+			// секрет версии 2 неизвестен хэшеру без ротации, хэш с ним не проверяется
+			if base.Verify("password", hashed) {
+				t.Error("Verify() = true for a hash with an unknown pepper version")
+			}
+		})
+	}
+}
+
+func TestPepperChangesHash(t *testing.T) {
+	if string(pepper("first", "password")) == string(pepper("second", "password")) {
+		t.Error("pepper() does not depend on the secret")
+	}
+	// результат фиксированной длины, bcrypt не обрезает длинные пароли
+	long := strings.Repeat("a", 100)
+	if string(pepper(testSecret, long)) == string(pepper(testSecret, long+"b")) || len(pepper(testSecret, long)) != 64 {
+		t.Error("pepper() must distinguish passwords longer than 72 bytes and return 64 hex chars")
+	}
+}
@@ -0,0 +1,112 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+var errMalformedHash = errors.New("malformed hash")
+
+// phcHash хэш в формате PHC string: $id$[v=version$]param=value,...$salt$hash
+type phcHash struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func (p phcHash) String() string {
+	var b strings.Builder
+	b.WriteString("$" + p.id)
+	if p.version != "" {
+		b.WriteString("$v=" + p.version)
+	}
+	b.WriteString("$")
+	b.WriteString(encodeParams(p.params, p.id))
+	b.WriteString("$" + base64.RawStdEncoding.EncodeToString(p.salt))
+	b.WriteString("$" + base64.RawStdEncoding.EncodeToString(p.hash))
+	return b.String()
+}
+
+func parsePHC(s string) (phcHash, error) {
+	parts := strings.Split(s, "$")
+	// первый элемент пустой, так как строка начинается с $
+	if len(parts) < 5 || parts[0] != "" {
+		return phcHash{}, errMalformedHash
+	}
+	p := phcHash{id: parts[1]}
+	parts = parts[2:]
+
+	if strings.HasPrefix(parts[0], "v=") {
+		p.version = strings.TrimPrefix(parts[0], "v=")
+		parts = parts[1:]
+	}
+	if len(parts) != 3 {
+		return phcHash{}, errMalformedHash
+	}
+
+	p.params = make(map[string]string)
+	for _, kv := range strings.Split(parts[0], ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return phcHash{}, errMalformedHash
+		}
+		p.params[k] = v
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil {
+		return phcHash{}, errMalformedHash
+	}
+	if p.hash, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil || len(p.hash) == 0 {
+		return phcHash{}, errMalformedHash
+	}
+	return p, nil
+}
+
+// encodeParams сохраняет порядок параметров, принятый для каждого алгоритма
+func encodeParams(params map[string]string, id string) string {
+	var order []string
+	switch id {
+	case Argon2id:
+		order = []string{"m", "t", "p", pepperParam}
+	case Scrypt:
+		order = []string{"ln", "r", "p", pepperParam}
+	}
+	values := make([]string, 0, len(params))
+	for _, k := range order {
+		if v, ok := params[k]; ok {
+			values = append(values, k+"="+v)
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func (p phcHash) intParam(name string) (int, error) {
+	v, ok := p.params[name]
+	if !ok {
+		return 0, errMalformedHash
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, errMalformedHash
+	}
+	return n, nil
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
@@ -0,0 +1,122 @@
+package hasher
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPHCRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		hash phcHash
+		want string
+	}{
+		{
+			name: "argon2id with version",
+			hash: phcHash{
+				id:      Argon2id,
+				version: "19",
+				params:  map[string]string{"p": "2", "t": "3", "m": "65536"},
+				salt:    []byte("0123456789abcdef"),
+				hash:    []byte("key"),
+			},
+			want: "$argon2id$v=19$m=65536,t=3,p=2$MDEyMzQ1Njc4OWFiY2RlZg$a2V5",
+		},
+		{
+			name: "argon2id with pepper version",
+			hash: phcHash{
+				id:      Argon2id,
+				version: "19",
+				params:  map[string]string{"m": "64", "t": "1", "p": "1", pepperParam: "2"},
+				salt:    []byte("salt"),
+				hash:    []byte("key"),
+			},
+			want: "$argon2id$v=19$m=64,t=1,p=1,pv=2$c2FsdA$a2V5",
+		},
+		{
+			name: "scrypt without version",
+			hash: phcHash{
+				id:     Scrypt,
+				params: map[string]string{"p": "1", "r": "8", "ln": "15"},
+				salt:   []byte("salt"),
+				hash:   []byte("key"),
+			},
+			want: "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.hash.String()
+			if got != tt.want {
+				t.Fatalf("String() = %q, want %q", got, tt.want)
+			}
+			parsed, err := parsePHC(got)
+			if err != nil {
+				t.Fatalf("parsePHC(%q) error: %s", got, err)
+			}
+			if parsed.id != tt.hash.id || parsed.version != tt.hash.version {
+				t.Errorf("parsePHC id, version = %q, %q, want %q, %q", parsed.id, parsed.version, tt.hash.id, tt.hash.version)
+			}
+			if len(parsed.params) != len(tt.hash.params) {
+				t.Errorf("parsePHC params = %v, want %v", parsed.params, tt.hash.params)
+			}
+			for k, v := range tt.hash.params {
+				if parsed.params[k] != v {
+					t.Errorf("parsePHC param %s = %q, want %q", k, parsed.params[k], v)
+				}
+			}
+			if !bytes.Equal(parsed.salt, tt.hash.salt) || !bytes.Equal(parsed.hash, tt.hash.hash) {
+				t.Errorf("parsePHC salt, hash = %q, %q, want %q, %q", parsed.salt, parsed.hash, tt.hash.salt, tt.hash.hash)
+			}
+		})
+	}
+}
+
+func TestParsePHCMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"no leading dollar", "argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5"},
+		{"truncated after params", "$argon2id$v=19$m=64,t=1,p=1"},
+		{"truncated after salt", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{"empty hash", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$"},
+		{"extra segment", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5$a2V5"},
+		{"param without value", "$argon2id$v=19$m=64,t,p=1$c2FsdA$a2V5"},
+		{"padded salt", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA==$a2V5"},
+		{"invalid hash encoding", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePHC(tt.hash); !errors.Is(err, errMalformedHash) {
+				t.Errorf("parsePHC(%q) error = %v, want %v", tt.hash, err, errMalformedHash)
+			}
+		})
+	}
+}
+
+func TestPHCIntParam(t *testing.T) {
+	p := phcHash{params: map[string]string{"m": "64", "t": "0", "p": "-1", "x": "abc"}}
+	tests := []struct {
+		name    string
+		param   string
+		want    int
+		wantErr bool
+	}{
+		{"positive", "m", 64, false},
+		{"zero", "t", 0, true},
+		{"negative", "p", 0, true},
+		{"not a number", "x", 0, true},
+		{"missing", "ln", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.intParam(tt.param)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("intParam(%q) = %d, %v, want %d, error %t", tt.param, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package hasher
+
+import (
+	"crypto/subtle"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"math/bits"
+	"strconv"
+)
+
+var defaultScryptParams = scryptParams{
+	n: 1 << 15,
+	r: 8,
+	p: 1,
+}
+
+type scryptParams struct {
+	n int
+	r int
+	p int
+}
+
+func (p scryptParams) validate() error {
+	if p.n <= 1 || p.n&(p.n-1) != 0 || p.r < 1 || p.p < 1 {
+		return errors.New("invalid scrypt params, n must be a power of two")
+	}
+	return nil
+}
+
+type scryptAlgorithm struct {
+	params scryptParams
+}
+
+func (a *scryptAlgorithm) hash(peppered []byte, pepperVersion int) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key(peppered, salt, a.params.n, a.params.r, a.params.p, keyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return phcHash{
+		id: Scrypt,
+		params: withPepperParam(map[string]string{
+			"ln": strconv.Itoa(bits.TrailingZeros(uint(a.params.n))),
+			"r":  strconv.Itoa(a.params.r),
+			"p":  strconv.Itoa(a.params.p),
+		}, pepperVersion),
+		salt: salt,
+		hash: key,
+	}.String(), nil
+}
+
+func (a *scryptAlgorithm) verify(peppered []byte, hashedPassword string) bool {
+	p, params, err := parseScrypt(hashedPassword)
+	if err != nil {
+		return false
+	}
+	key, err := scrypt.Key(peppered, p.salt, params.n, params.r, params.p, len(p.hash))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, p.hash) == 1
+}
+
+func (a *scryptAlgorithm) needsRehash(hashedPassword string) bool {
+	_, params, err := parseScrypt(hashedPassword)
+	return err != nil || params != a.params
+}
+
+func parseScrypt(hashedPassword string) (phcHash, scryptParams, error) {
+	p, err := parsePHC(hashedPassword)
+	if err != nil || p.id != Scrypt {
+		return phcHash{}, scryptParams{}, errMalformedHash
+	}
+	ln, err := p.intParam("ln")
+	if err != nil || ln >= 31 {
+		return phcHash{}, scryptParams{}, errMalformedHash
+	}
+	r, err := p.intParam("r")
+	if err != nil {
+		return phcHash{}, scryptParams{}, err
+	}
+	par, err := p.intParam("p")
+	if err != nil {
+		return phcHash{}, scryptParams{}, err
+	}
+	return p, scryptParams{n: 1 << ln, r: r, p: par}, nil
+}
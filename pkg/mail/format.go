@@ -0,0 +1,119 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// formatter форматирует время и длительность на языке письма. Для локалей без своего formatter
+// (например, добавленных в MAIL_TEMPLATES_DIR) используется английский
+type formatter struct {
+	months []string
+	// units формы единиц измерения: дни, часы, минуты, секунды
+	units  [4]func(n int) string
+	layout func(t time.Time, month string) string
+}
+
+var formatters = map[string]formatter{
+	"en": {
+		months: []string{"January", "February", "March", "April", "May", "June", "July", "August", "September",
+			"October", "November", "December"},
+		units: [4]func(n int) string{
+			enPlural("day", "days"),
+			enPlural("hour", "hours"),
+			enPlural("minute", "minutes"),
+			enPlural("second", "seconds"),
+		},
+		layout: func(t time.Time, month string) string {
+			return fmt.Sprintf("%s %d, %d %s UTC", month, t.Day(), t.Year(), t.Format("15:04:05"))
+		},
+	},
+	"ru": {
+		months: []string{"января", "февраля", "марта", "апреля", "мая", "июня", "июля", "августа", "сентября",
+			"октября", "ноября", "декабря"},
+		units: [4]func(n int) string{
+			ruPlural("день", "дня", "дней"),
+			ruPlural("час", "часа", "часов"),
+			ruPlural("минута", "минуты", "минут"),
+			ruPlural("секунда", "секунды", "секунд"),
+		},
+		layout: func(t time.Time, month string) string {
+			return fmt.Sprintf("%d %s %d %s UTC", t.Day(), month, t.Year(), t.Format("15:04:05"))
+		},
+	},
+}
+
+func formatterFor(locale string) formatter {
+	locale = NormalizeLocale(locale)
+	if f, ok := formatters[locale]; ok {
+		return f
+	}
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		if f, ok := formatters[locale[:i]]; ok {
+			return f
+		}
+	}
+	return formatters["en"]
+}
+
+// templateFuncs функции шаблонов локали: {{datetime .Time}} - "2 января 2026 15:04:05 UTC",
+// {{duration .TTL}} - "1 час 30 минут"
+func templateFuncs(locale string) map[string]any {
+	f := formatterFor(locale)
+	return map[string]any{
+		"datetime": f.datetime,
+		"duration": f.duration,
+	}
+}
+
+func (f formatter) datetime(t time.Time) string {
+	t = t.UTC()
+	return f.layout(t, f.months[t.Month()-1])
+}
+
+// duration округляет до секунд и пропускает нулевые единицы, секунды выводятся только для длительностей меньше часа
+func (f formatter) duration(d time.Duration) string {
+	d = d.Round(time.Second)
+	values := [4]int{
+		int(d / (24 * time.Hour)),
+		int(d % (24 * time.Hour) / time.Hour),
+		int(d % time.Hour / time.Minute),
+		int(d % time.Minute / time.Second),
+	}
+	if d >= time.Hour {
+		values[3] = 0
+	}
+	var parts []string
+	for i, n := range values {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, f.units[i](n)))
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("0 %s", f.units[3](0))
+	}
+	return strings.Join(parts, " ")
+}
+
+func enPlural(one, many string) func(n int) string {
+	return func(n int) string {
+		if n == 1 {
+			return one
+		}
+		return many
+	}
+}
+
+// ruPlural 1 минута, 2-4 минуты, 5-20 минут, 21 минута
+func ruPlural(one, few, many string) func(n int) string {
+	return func(n int) string {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return one
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return few
+		}
+		return many
+	}
+}
@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		locale string
+		d      time.Duration
+		want   string
+	}{
+		{"en", 0, "0 seconds"},
+		{"en", time.Second, "1 second"},
+		{"en", 90 * time.Second, "1 minute 30 seconds"},
+		{"en", 1500 * time.Millisecond, "2 seconds"},
+		{"en", time.Hour + 30*time.Minute + 15*time.Second, "1 hour 30 minutes"},
+		{"en", 49 * time.Hour, "2 days 1 hour"},
+		{"ru", time.Minute, "1 минута"},
+		{"ru", 3 * time.Minute, "3 минуты"},
+		{"ru", 11 * time.Minute, "11 минут"},
+		{"ru", 21 * time.Minute, "21 минута"},
+		{"ru", 24 * time.Hour, "1 день"},
+		{"ru", 5 * time.Hour, "5 часов"},
+		{"ru-RU", 2 * time.Hour, "2 часа"},
+		{"de", 2 * time.Hour, "2 hours"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale+"/"+tt.d.String(), func(t *testing.T) {
+			if got := formatterFor(tt.locale).duration(tt.d); got != tt.want {
+				t.Errorf("duration(%s) = %q, want %q", tt.d, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatetime(t *testing.T) {
+	at := time.Date(2026, 1, 2, 18, 4, 5, 0, time.FixedZone("MSK", 3*60*60))
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", "January 2, 2026 15:04:05 UTC"},
+		{"ru", "2 января 2026 15:04:05 UTC"},
+		{"pt-br", "January 2, 2026 15:04:05 UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			if got := formatterFor(tt.locale).datetime(at); got != tt.want {
+				t.Errorf("datetime() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuPlural(t *testing.T) {
+	plural := ruPlural("one", "few", "many")
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "many"},
+		{1, "one"},
+		{2, "few"},
+		{4, "few"},
+		{5, "many"},
+		{11, "many"},
+		{12, "many"},
+		{14, "many"},
+		{21, "one"},
+		{22, "few"},
+		{101, "one"},
+		{111, "many"},
+		{112, "many"},
+	}
+	for _, tt := range tests {
+		if got := plural(tt.n); got != tt.want {
+			t.Errorf("ruPlural(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const crlf = "\r\n"
+
+// Content отрендеренное письмо: тема, текстовая и html версии
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Compose собирает письмо multipart/alternative с заголовками From, To, Subject, Date и Message-ID.
+// Строки разделяются CRLF, части кодируются quoted-printable, поэтому письмо можно передавать в smtp как есть
+func Compose(from, to string, c Content, date time.Time) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address: %w", err)
+	}
+	messageId, err := newMessageId(sender.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err = writePart(mw, "text/plain", c.Text); err != nil {
+		return nil, err
+	}
+	if err = writePart(mw, "text/html", c.HTML); err != nil {
+		return nil, err
+	}
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := func(key, value string) {
+		msg.WriteString(key + ": " + value + crlf)
+	}
+	header("From", sender.String())
+	header("To", recipient.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", c.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageId)
+	header("MIME-Version", "1.0")
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}))
+	msg.WriteString(crlf)
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func writePart(mw *multipart.Writer, contentType, content string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"charset": "utf-8"})},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	// quotedprintable сам переводит переводы строк в CRLF
+	qp := quotedprintable.NewWriter(part)
+	if _, err = qp.Write([]byte(strings.ReplaceAll(content, crlf, "\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func newMessageId(sender string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(sender, "@"); i >= 0 && i < len(sender)-1 {
+		domain = sender[i+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
@@ -0,0 +1,145 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComposeHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{"crlf in to", "noreply@example.com", "user@example.com\r\nBcc: victim@example.com"},
+		{"lf in to", "noreply@example.com", "user@example.com\nBcc: victim@example.com"},
+		{"crlf in display name", "noreply@example.com", "User\r\nBcc: victim@example.com <user@example.com>"},
+		{"crlf in from", "noreply@example.com\r\nBcc: victim@example.com", "user@example.com"},
+		{"several recipients", "noreply@example.com", "user@example.com, victim@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compose(tt.from, tt.to, Content{Subject: "s", Text: "t", HTML: "h"}, time.Now()); err == nil {
+				t.Errorf("Compose(%q, %q) error = nil", tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestComposeSubjectInjection(t *testing.T) {
+	subject := "Hello\r\nBcc: victim@example.com\r\n\r\nbody"
+	raw, err := Compose("noreply@example.com", "user@example.com", Content{Subject: subject, Text: "t", HTML: "h"}, time.Now())
+	if err != nil {
+		t.Fatalf("Compose error: %s", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage error: %s", err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("injected Bcc header %q", bcc)
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("DecodeHeader error: %s", err)
+	}
+	if decoded != subject {
+		t.Errorf("Subject = %q, want %q", decoded, subject)
+	}
+}
+
+func TestComposeMultipart(t *testing.T) {
+	date := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	content := Content{
+		Subject: "Подтверждение почты",
+		Text:    "Привет!\nСсылка: https://example.com/verify?token=" + strings.Repeat("a", 100) + "\n",
+		HTML:    "<p>Привет!</p>",
+	}
+	raw, err := Compose("Company <noreply@example.com>", "user@example.com", content, date)
+	if err != nil {
+		t.Fatalf("Compose error: %s", err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(raw), "\r\n"), "\r\n") {
+		if strings.ContainsAny(line, "\r\n") {
+			t.Fatalf("line %q is not terminated by CRLF", line)
+		}
+		if len(line) > 998 {
+			t.Fatalf("line longer than 998 octets: %d", len(line))
+		}
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage error: %s", err)
+	}
+	headers := map[string]string{
+		"From":         `"Company" <noreply@example.com>`,
+		"To":           "<user@example.com>",
+		"Date":         "Fri, 02 Jan 2026 15:04:05 +0000",
+		"Mime-Version": "1.0",
+	}
+	for k, v := range headers {
+		if got := msg.Header.Get(k); got != v {
+			t.Errorf("header %s = %q, want %q", k, got, v)
+		}
+	}
+	if id := msg.Header.Get("Message-Id"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q, want <...@example.com>", id)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != content.Subject {
+		t.Errorf("Subject = %q, %v, want %q", subject, err, content.Subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v, want multipart/alternative", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", content.Text},
+		{"text/html", content.HTML},
+	}
+	for _, w := range want {
+		part, err := mr.NextRawPart()
+		if err != nil {
+			t.Fatalf("NextRawPart error: %s", err)
+		}
+		partType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil || partType != w.contentType || partParams["charset"] != "utf-8" {
+			t.Errorf("part Content-Type = %q, %v, want %s; charset=utf-8", part.Header.Get("Content-Type"), err, w.contentType)
+		}
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+			t.Errorf("part Content-Transfer-Encoding = %q, want quoted-printable", enc)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("read part error: %s", err)
+		}
+		if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != w.body {
+			t.Errorf("part %s body = %q, want %q", w.contentType, got, w.body)
+		}
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got error %v", err)
+	}
+}
+
+func TestComposeInvalidAddress(t *testing.T) {
+	if _, err := Compose("not an address", "user@example.com", Content{}, time.Now()); err == nil {
+		t.Error("Compose() with invalid from error = nil")
+	}
+	if _, err := Compose("noreply@example.com", "", Content{}, time.Now()); err == nil {
+		t.Error("Compose() with empty to error = nil")
+	}
+}
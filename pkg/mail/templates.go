@@ -0,0 +1,186 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	layoutFile   = "layout.html"
+	subjectBlock = "subject"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+var ErrTemplateNotFound = errors.New("mail template not found")
+
+// Templates шаблоны писем по локалям. Письмо name в локали locale описывают два файла: <locale>/<name>.txt
+// (text/template, тема задается блоком {{define "subject"}}) и <locale>/<name>.html (html/template,
+// подставляется в общий layout.html как блок "content"). Время и длительность выводятся функциями
+// datetime и duration на языке шаблона
+type Templates struct {
+	defaultLocale string
+	text          map[string]*texttemplate.Template
+	html          map[string]*htmltemplate.Template
+}
+
+// LoadTemplates загружает встроенные шаблоны и заменяет их файлами из overrideDir с теми же путями.
+// В overrideDir можно добавить и новую локаль. Все шаблоны разбираются сразу, чтобы ошибка в них
+// обнаруживалась при старте, а не при отправке письма
+func LoadTemplates(overrideDir, defaultLocale string) (*Templates, error) {
+	base, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return nil, err
+	}
+	layers := []fs.FS{base}
+	if overrideDir != "" {
+		if _, err = os.Stat(overrideDir); err != nil {
+			return nil, err
+		}
+		layers = append([]fs.FS{os.DirFS(overrideDir)}, layers...)
+	}
+
+	t := &Templates{
+		defaultLocale: NormalizeLocale(defaultLocale),
+		text:          make(map[string]*texttemplate.Template),
+		html:          make(map[string]*htmltemplate.Template),
+	}
+	layout, err := readFile(layers, layoutFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range listFiles(layers) {
+		locale, name := path.Dir(file), path.Base(file)
+		src, err := readFile(layers, file)
+		if err != nil {
+			return nil, err
+		}
+		key := NormalizeLocale(locale) + "/" + strings.TrimSuffix(name, path.Ext(name))
+
+		switch path.Ext(name) {
+		case ".txt":
+			tmpl, err := texttemplate.New(name).Option("missingkey=error").Funcs(templateFuncs(locale)).Parse(src)
+			if err != nil {
+				return nil, err
+			}
+			if tmpl.Lookup(subjectBlock) == nil {
+				return nil, fmt.Errorf("mail template %s has no subject block", file)
+			}
+			t.text[key] = tmpl
+		case ".html":
+			tmpl, err := htmltemplate.New(layoutFile).Option("missingkey=error").Funcs(templateFuncs(locale)).Parse(layout)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = tmpl.New("content").Parse(src); err != nil {
+				return nil, err
+			}
+			t.html[key] = tmpl
+		}
+	}
+
+	if len(t.text) == 0 {
+		return nil, errors.New("no mail templates found")
+	}
+	hasDefault := false
+	for key := range t.text {
+		if _, ok := t.html[key]; !ok {
+			return nil, fmt.Errorf("mail template %s has no html version", key)
+		}
+		if strings.HasPrefix(key, t.defaultLocale+"/") {
+			hasDefault = true
+		}
+	}
+	// без шаблонов локали по умолчанию письма на неизвестных языках не смогут отрендериться
+	if !hasDefault {
+		return nil, fmt.Errorf("no mail templates for default locale %q", t.defaultLocale)
+	}
+	return t, nil
+}
+
+// Render рендерит письмо name для локали пользователя. Если шаблона нет для "pt-br", используется "pt",
+// затем локаль по умолчанию
+func (t *Templates) Render(locale, name string, data any) (Content, error) {
+	key, ok := t.resolve(locale, name)
+	if !ok {
+		return Content{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	text := t.text[key]
+
+	var subject, plain, html bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, subjectBlock, data); err != nil {
+		return Content{}, err
+	}
+	if err := text.Execute(&plain, data); err != nil {
+		return Content{}, err
+	}
+	if err := t.html[key].Execute(&html, data); err != nil {
+		return Content{}, err
+	}
+	return Content{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(plain.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+func (t *Templates) resolve(locale, name string) (string, bool) {
+	locale = NormalizeLocale(locale)
+	candidates := []string{locale}
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		candidates = append(candidates, locale[:i])
+	}
+	candidates = append(candidates, t.defaultLocale)
+	for _, c := range candidates {
+		key := c + "/" + name
+		if _, ok := t.text[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// NormalizeLocale приводит "pt_BR" и "PT-br" к "pt-br"
+func NormalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// listFiles файлы шаблонов <locale>/<name>.txt|html из всех слоев без повторов
+func listFiles(layers []fs.FS) []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, layer := range layers {
+		matches, _ := fs.Glob(layer, "*/*")
+		for _, m := range matches {
+			if ext := path.Ext(m); (ext == ".txt" || ext == ".html") && !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files
+}
+
+// readFile читает файл из первого слоя, в котором он есть
+func readFile(layers []fs.FS, name string) (string, error) {
+	for _, layer := range layers {
+		b, err := fs.ReadFile(layer, name)
+		if err == nil {
+			return string(b), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
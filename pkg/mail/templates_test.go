@@ -0,0 +1,157 @@
+package mail
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testData все поля, которые используют встроенные шаблоны. С missingkey=error пропущенное поле
+// сломает рендеринг, поэтому тест заметит новое поле шаблона без данных
+func testData() map[string]any {
+	return map[string]any{
+		"Addr":     "198.51.100.1",
+		"Link":     "https://example.com/confirm?token=abc&next=%2F",
+		"NewEmail": "new@example.com",
+		"Token":    "abc",
+		"Name":     "<b>key</b>",
+		"Time":     time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+		"Duration": 90 * time.Minute,
+		"TTL":      time.Hour,
+	}
+}
+
+func TestRenderEmbedded(t *testing.T) {
+	tmpl, err := LoadTemplates("", "en")
+	if err != nil {
+		t.Fatalf("LoadTemplates error: %s", err)
+	}
+	files, err := fs.Glob(embedded, "templates/*/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no embedded templates: %v", err)
+	}
+	for _, file := range files {
+		locale := path.Base(path.Dir(file))
+		name := strings.TrimSuffix(path.Base(file), ".txt")
+		t.Run(locale+"/"+name, func(t *testing.T) {
+			c, err := tmpl.Render(locale, name, testData())
+			if err != nil {
+				t.Fatalf("Render error: %s", err)
+			}
+			if c.Subject == "" || strings.ContainsAny(c.Subject, "\r\n") {
+				t.Errorf("Subject = %q, want a single non-empty line", c.Subject)
+			}
+			if strings.Contains(c.Text, "{{") || !strings.HasSuffix(c.Text, "\n") {
+				t.Errorf("Text = %q, want rendered text ending with a newline", c.Text)
+			}
+			if !strings.Contains(c.HTML, "<body") || strings.Contains(c.HTML, "{{") {
+				t.Errorf("HTML = %q, want the layout with rendered content", c.HTML)
+			}
+			// html/template экранирует данные пользователя
+			if strings.Contains(c.HTML, "<b>key</b>") {
+				t.Errorf("HTML contains unescaped data: %q", c.HTML)
+			}
+		})
+	}
+}
+
+func TestRenderLocale(t *testing.T) {
+	tmpl, err := LoadTemplates("", "en")
+	if err != nil {
+		t.Fatalf("LoadTemplates error: %s", err)
+	}
+	ru, err := tmpl.Render("ru", "magic_link", testData())
+	if err != nil {
+		t.Fatalf("Render error: %s", err)
+	}
+	en, err := tmpl.Render("en", "magic_link", testData())
+	if err != nil {
+		t.Fatalf("Render error: %s", err)
+	}
+	tests := []struct {
+		locale string
+		want   Content
+	}{
+		{"ru", ru},
+		{"ru_RU", ru},
+		{"RU-ru", ru},
+		{"en-GB", en},
+		{"pt-br", en},
+		{"", en},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			c, err := tmpl.Render(tt.locale, "magic_link", testData())
+			if err != nil {
+				t.Fatalf("Render error: %s", err)
+			}
+			if c.Subject != tt.want.Subject {
+				t.Errorf("Render(%q) subject = %q, want %q", tt.locale, c.Subject, tt.want.Subject)
+			}
+		})
+	}
+
+	if _, err = tmpl.Render("en", "unknown", testData()); err == nil {
+		t.Error("Render() of an unknown template error = nil")
+	}
+	if _, err = tmpl.Render("en", "magic_link", map[string]any{}); err == nil {
+		t.Error("Render() with missing data error = nil")
+	}
+}
+
+func TestLoadTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("en/magic_link.txt", `{{define "subject"}}Custom {{.Token}}{{end}}Custom body`)
+	write("de/magic_link.txt", `{{define "subject"}}Anmeldung{{end}}Link {{.Link}}`)
+	write("de/magic_link.html", `<a href="{{.Link}}">Anmelden</a>`)
+
+	tmpl, err := LoadTemplates(dir, "en")
+	if err != nil {
+		t.Fatalf("LoadTemplates error: %s", err)
+	}
+	c, err := tmpl.Render("en", "magic_link", testData())
+	if err != nil || c.Subject != "Custom abc" || c.Text != "Custom body\n" {
+		t.Errorf("overridden template = %+v, %v", c, err)
+	}
+	c, err = tmpl.Render("de-AT", "magic_link", testData())
+	if err != nil || c.Subject != "Anmeldung" || !strings.Contains(c.HTML, "Anmelden") {
+		t.Errorf("added locale template = %+v, %v", c, err)
+	}
+
+	invalid := []struct {
+		name    string
+		files   map[string]string
+		locale  string
+		wantErr bool
+	}{
+		{"no subject block", map[string]string{"en/custom.txt": "body", "en/custom.html": "body"}, "en", true},
+		{"no html version", map[string]string{"en/custom.txt": `{{define "subject"}}s{{end}}body`}, "en", true},
+		{"syntax error", map[string]string{"en/custom.txt": `{{define "subject"}}s{{end}}{{.Link`}, "en", true},
+		{"unknown default locale", nil, "fr", true},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			dir = t.TempDir()
+			for name, content := range tt.files {
+				write(name, content)
+			}
+			if _, err := LoadTemplates(dir, tt.locale); (err != nil) != tt.wantErr {
+				t.Errorf("LoadTemplates() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package signkey
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyRing хранит активный ключ подписи и ключи, которые используются только для проверки подписи.
+// Ротация выполняется в два шага: новый ключ сначала добавляется как проверочный (и попадает в jwks),
+// затем становится активным, а старый остается проверочным до своего ExpiresAt
+type KeyRing struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+func NewKeyRing(active *Key, verify ...*Key) (*KeyRing, error) {
+	if active == nil || !active.CanSign() {
+		return nil, errors.New("active key must contain a private key")
+	}
+	r := &KeyRing{
+		active: active,
+		keys:   map[string]*Key{active.Id: active},
+	}
+	for _, k := range verify {
+		if _, ok := r.keys[k.Id]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.Id)
+		}
+		r.keys[k.Id] = k
+	}
+	return r, nil
+}
+
+func (r *KeyRing) Active() *Key {
+	return r.active
+}
+
+// Lookup возвращает ключ по kid. Ключ с истекшим сроком действия считается удаленным
+func (r *KeyRing) Lookup(kid string) (*Key, error) {
+	k, ok := r.keys[kid]
+	if !ok || k.Expired(time.Now()) {
+		return nil, ErrKeyNotFound
+	}
+	return k, nil
+}
+
+// Keys возвращает все действующие ключи, начиная с активного
+func (r *KeyRing) Keys() []*Key {
+	now := time.Now()
+	keys := []*Key{r.active}
+	for _, k := range r.keys {
+		if k != r.active && !k.Expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// LoadSpec загружает проверочный ключ по описанию вида kid:alg:path[:expires], где expires в формате RFC 3339.
+// Для HS* алгоритмов файл содержит секрет, для остальных PEM ключ. Пустой kid вычисляется из публичного ключа
+func LoadSpec(spec string) (*Key, error) {
+	parts := strings.SplitN(spec, ":", 4)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid key spec %q, expected kid:alg:path[:expires]", spec)
+	}
+	kid, alg, path := parts[0], parts[1], parts[2]
+
+	var (
+		k   *Key
+		err error
+	)
+	if strings.HasPrefix(alg, "HS") {
+		secret, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, fmt.Errorf("read key file: %w", readErr)
+		}
+		if kid == "" {
+			return nil, fmt.Errorf("key spec %q: kid is required for hmac keys", spec)
+		}
+		k, err = NewHMAC(alg, kid, strings.TrimSpace(string(secret)))
+	} else {
+		k, err = Load(alg, kid, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 4 && parts[3] != "" {
+		k.ExpiresAt, err = time.Parse(time.RFC3339, parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("key spec %q: invalid expiry: %w", spec, err)
+		}
+	}
+	// проверочный ключ никогда не используется для подписи
+	k.Private = nil
+	return k, nil
+}
@@ -0,0 +1,191 @@
+package signkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"os"
+	"time"
+)
+
+const minRSAKeyBits = 2048
+
+var (
+	ErrUnsupportedMethod = errors.New("unsupported sign method")
+	ErrKeyMismatch       = errors.New("key does not match sign method")
+	ErrInvalidPEM        = errors.New("invalid pem key")
+)
+
+// Key ключ подписи jwt. Для HMAC Private и Public содержат один и тот же секрет,
+// для асимметричных алгоритмов Private может быть nil, если ключ используется только для проверки подписи
+type Key struct {
+	Id        string
+	Method    jwt.SigningMethod
+	Private   interface{}
+	Public    interface{}
+	ExpiresAt time.Time // срок действия выведенного из оборота ключа, нулевое значение - бессрочно
+}
+
+func (k *Key) CanSign() bool {
+	return k.Private != nil
+}
+
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// NewHMAC создает симметричный ключ для алгоритмов HS256, HS384 и HS512
+func NewHMAC(alg, kid, secret string) (*Key, error) {
+	method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, alg)
+	}
+	if secret == "" {
+		return nil, errors.New("empty hmac secret")
+	}
+	if kid == "" {
+		kid = "default"
+	}
+	return &Key{
+		Id:      kid,
+		Method:  method,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}, nil
+}
+
+// Load читает PEM файл с приватным (PKCS#1, PKCS#8, SEC 1) или публичным (PKIX) ключом для алгоритмов
+// RS*, PS*, ES* и EdDSA. Если kid не задан, он вычисляется из публичного ключа
+func Load(alg, kid, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return Parse(alg, kid, data)
+}
+
+func Parse(alg, kid string, data []byte) (*Key, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, alg)
+	}
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		return nil, fmt.Errorf("%w: %s uses a shared secret, not a pem key", ErrUnsupportedMethod, alg)
+	}
+
+	private, public, err := parsePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMethod(method, public); err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		if kid, err = thumbprint(public); err != nil {
+			return nil, err
+		}
+	}
+
+	k := &Key{
+		Id:     kid,
+		Method: method,
+		Public: public,
+	}
+	// nil crypto.Signer в интерфейсе не равен nil, поэтому присваиваем только существующий ключ
+	if private != nil {
+		k.Private = private
+	}
+	return k, nil
+}
+
+func parsePEM(data []byte) (crypto.Signer, crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, ErrInvalidPEM
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPEM, err)
+		}
+		return nil, public, nil
+	case "RSA PUBLIC KEY":
+		public, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPEM, err)
+		}
+		return nil, public, nil
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPEM, err)
+		}
+		return private, private.Public(), nil
+	case "EC PRIVATE KEY":
+		private, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPEM, err)
+		}
+		return private, private.Public(), nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPEM, err)
+		}
+		private, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unsupported private key type %T", ErrInvalidPEM, key)
+		}
+		return private, private.Public(), nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported block type %q", ErrInvalidPEM, block.Type)
+	}
+}
+
+func checkMethod(method jwt.SigningMethod, public crypto.PublicKey) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		k, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s requires rsa key, got %T", ErrKeyMismatch, method.Alg(), public)
+		}
+		if k.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("%w: rsa key must be at least %d bits", ErrKeyMismatch, minRSAKeyBits)
+		}
+	case *jwt.SigningMethodECDSA:
+		k, ok := public.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s requires ecdsa key, got %T", ErrKeyMismatch, method.Alg(), public)
+		}
+		if k.Curve.Params().BitSize != m.CurveBits {
+			return fmt.Errorf("%w: %s requires %d bit curve", ErrKeyMismatch, method.Alg(), m.CurveBits)
+		}
+	case *jwt.SigningMethodEd25519:
+		if _, ok := public.(ed25519.PublicKey); !ok {
+			return fmt.Errorf("%w: %s requires ed25519 key, got %T", ErrKeyMismatch, method.Alg(), public)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedMethod, method.Alg())
+	}
+	return nil
+}
+
+// thumbprint короткий идентификатор ключа: sha256 от DER кодировки публичного ключа
+func thumbprint(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
@@ -0,0 +1,47 @@
+package smtp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir складывает письма в каталог формата maildir (tmp, new, cur), который читают почтовые клиенты
+// вроде mutt. Заголовок Delivered-To добавляется, чтобы в файле был виден получатель
+type Maildir struct {
+	dir      string
+	hostname string
+	counter  atomic.Uint64
+}
+
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &Maildir{dir: dir, hostname: hostname}, nil
+}
+
+// SendMail пишет письмо в tmp и переносит в new, поэтому читатель никогда не увидит недописанный файл
+func (m *Maildir) SendMail(to, text string) error {
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), m.counter.Add(1), m.hostname)
+
+	tmp := filepath.Join(m.dir, "tmp", name)
+	content := "Delivered-To: " + to + "\r\n" + text
+	if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(m.dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
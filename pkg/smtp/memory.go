@@ -0,0 +1,41 @@
+package smtp
+
+import "sync"
+
+type Message struct {
+	To   string
+	Text string
+}
+
+// Recorder запоминает письма в памяти, чтобы тесты могли проверить, что и кому было отправлено
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) SendMail(to, text string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, Message{To: to, Text: text})
+	return nil
+}
+
+// Messages копия отправленных писем в порядке отправки
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
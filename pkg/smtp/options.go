@@ -0,0 +1,45 @@
+package smtp
+
+import "time"
+
+type Option func(c *client)
+
+func Host(host string) Option {
+	return func(c *client) {
+		c.host = host
+	}
+}
+
+func Port(port string) Option {
+	return func(c *client) {
+		c.port = port
+	}
+}
+
+func TLS(mode TLSMode) Option {
+	return func(c *client) {
+		c.tlsMode = mode
+	}
+}
+
+// From адрес отправителя в команде MAIL FROM
+func From(from string) Option {
+	return func(c *client) {
+		c.from = from
+	}
+}
+
+func Auth(method AuthMethod, login, password string) Option {
+	return func(c *client) {
+		c.method = method
+		c.login = login
+		c.password = password
+	}
+}
+
+// Timeout ограничивает подключение и весь обмен с сервером для одного письма
+func Timeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.timeout = timeout
+	}
+}
@@ -1,35 +1,199 @@
 package smtp
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/smtp"
+	"time"
 )
 
 const (
-	defaultHost = "smtp.gmail.com"
-	defaultPort = "587"
+	defaultHost    = "smtp.gmail.com"
+	defaultPort    = "587"
+	defaultTimeout = 10 * time.Second
 )
 
+// TLSMode способ защиты соединения с smtp сервером
+type TLSMode string
+
+const (
+	// TLSStartTLS соединение без шифрования с обязательным переходом на TLS командой STARTTLS (обычно порт 587)
+	TLSStartTLS TLSMode = "starttls"
+	// TLSImplicit TLS с момента подключения (обычно порт 465)
+	TLSImplicit TLSMode = "tls"
+	// TLSNone без шифрования, только для локальных серверов
+	TLSNone TLSMode = "none"
+)
+
+// AuthMethod механизм SMTP AUTH
+type AuthMethod string
+
+const (
+	AuthPlain   AuthMethod = "plain"
+	AuthLogin   AuthMethod = "login"
+	AuthCRAMMD5 AuthMethod = "cram-md5"
+	AuthNone    AuthMethod = "none"
+)
+
+var ErrStartTLSUnsupported = errors.New("smtp server does not support STARTTLS")
+
+// Smtp транспорт для отправки писем. text - сообщение целиком, с заголовками
 type Smtp interface {
 	SendMail(to, text string) error
 }
 
 type client struct {
-	auth   smtp.Auth
-	sender string
-	host   string
-	port   string
+	host     string
+	port     string
+	tlsMode  TLSMode
+	from     string
+	method   AuthMethod
+	login    string
+	password string
+	timeout  time.Duration
 }
 
-func NewSmtp(login, password string) Smtp {
-	return &client{
-		auth:   smtp.PlainAuth("", login, password, defaultHost),
-		sender: login,
-		host:   defaultHost,
-		port:   defaultPort,
+// NewSmtp транспорт через smtp сервер. По умолчанию smtp.gmail.com:587 со STARTTLS и PLAIN авторизацией,
+// отправитель по умолчанию совпадает с логином
+func NewSmtp(opts ...Option) (Smtp, error) {
+	c := &client{
+		host:    defaultHost,
+		port:    defaultPort,
+		tlsMode: TLSStartTLS,
+		method:  AuthPlain,
+		timeout: defaultTimeout,
+	}
+	for _, option := range opts {
+		option(c)
+	}
+
+	if c.from == "" {
+		c.from = c.login
 	}
+	if c.from == "" {
+		return nil, errors.New("smtp sender address is not set")
+	}
+	switch c.tlsMode {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q", c.tlsMode)
+	}
+	switch c.method {
+	case AuthNone:
+	case AuthPlain, AuthLogin, AuthCRAMMD5:
+		if c.login == "" {
+			return nil, fmt.Errorf("smtp auth %s requires login", c.method)
+		}
+	default:
+		return nil, fmt.Errorf("unknown smtp auth method %q", c.method)
+	}
+	return c, nil
 }
 
 func (c *client) SendMail(to, text string) error {
-	return smtp.SendMail(net.JoinHostPort(c.host, c.port), c.auth, c.sender, []string{to}, []byte(text))
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	if err = conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	cl, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = cl.Close() }()
+
+	if c.tlsMode == TLSStartTLS {
+		if ok, _ := cl.Extension("STARTTLS"); !ok {
+			return ErrStartTLSUnsupported
+		}
+		if err = cl.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return err
+		}
+	}
+	if auth := c.auth(); auth != nil {
+		if err = cl.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err = cl.Mail(c.from); err != nil {
+		return err
+	}
+	if err = cl.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := cl.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte(text)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return cl.Quit()
+}
+
+func (c *client) dial() (net.Conn, error) {
+	addr := net.JoinHostPort(c.host, c.port)
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if c.tlsMode == TLSImplicit {
+		return tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: c.host})
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// auth PLAIN из net/smtp сам отказывается передавать пароль без TLS на нелокальный сервер
+func (c *client) auth() smtp.Auth {
+	switch c.method {
+	case AuthPlain:
+		return smtp.PlainAuth("", c.login, c.password, c.host)
+	case AuthLogin:
+		return &loginAuth{login: c.login, password: c.password, host: c.host}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(c.login, c.password)
+	}
+	return nil
+}
+
+// loginAuth механизм LOGIN, которого нет в net/smtp, но который до сих пор требуют некоторые серверы (Office 365)
+type loginAuth struct {
+	login    string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.login), nil
+	case "Password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
 }
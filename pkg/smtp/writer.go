@@ -0,0 +1,25 @@
+package smtp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Writer печатает письма вместо отправки, например в stdout при локальной разработке
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) SendMail(to, text string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := fmt.Fprintf(w.w, "----- mail to %s -----\n%s\n----- end of mail -----\n", to, text)
+	return err
+}
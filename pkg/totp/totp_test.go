@@ -0,0 +1,135 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// секрет из тестовых векторов RFC 4226 и RFC 6238 (SHA1)
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := Code(rfcSecret, int64(counter)); got != code {
+			t.Errorf("Code(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// TestValidateRFC6238 коды RFC 6238 даны для 8 цифр, для 6 цифр берутся последние шесть
+func TestValidateRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		if got := Code(rfcSecret, Step(at)); got != tt.code {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+		step, ok := Validate(rfcSecret, tt.code, at, 0)
+		if !ok || step != Step(at) {
+			t.Errorf("Validate(%s, %d) = %d, %t, want %d, true", tt.code, tt.unix, step, ok, Step(at))
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	tests := []struct {
+		name   string
+		step   int64
+		skew   int
+		wantOk bool
+	}{
+		{"current step", current, 0, true},
+		{"previous step without skew", current - 1, 0, false},
+		{"previous step within skew", current - 1, 1, true},
+		{"next step within skew", current + 1, 1, true},
+		{"two steps behind with skew 1", current - 2, 1, false},
+		{"two steps ahead with skew 2", current + 2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, Code(rfcSecret, tt.step), now, tt.skew)
+			if ok != tt.wantOk {
+				t.Fatalf("Validate() ok = %t, want %t", ok, tt.wantOk)
+			}
+			if ok && step != tt.step {
+				t.Errorf("Validate() step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		code   string
+		wantOk bool
+	}{
+		{"exact", "287082", true},
+		{"surrounding spaces", " 287082 ", true},
+		{"grouped digits", "287 082", true},
+		{"wrong code", "287083", false},
+		{"too short", "28708", false},
+		{"eight digits", "94287082", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(rfcSecret, tt.code, now, 0); ok != tt.wantOk {
+				t.Errorf("Validate(%q) ok = %t, want %t", tt.code, ok, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("test auth", "user@example.com", rfcSecret)
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error: %s", uri, err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/test auth:user@example.com" {
+		t.Errorf("URI() = %q, unexpected scheme, type or label", uri)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"secret":    "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"issuer":    "test auth",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("URI() %s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret error: %s", err)
+	}
+	second, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret error: %s", err)
+	}
+	if len(first) != SecretSize || string(first) == string(second) {
+		t.Errorf("GenerateSecret() must return random %d byte secrets", SecretSize)
+	}
+}
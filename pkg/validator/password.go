@@ -0,0 +1,138 @@
+package validator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy правила для новых паролей. Нулевые значения отключают соответствующее правило
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// MinEntropy минимальная оценка PasswordEntropy в битах
+	MinEntropy float64
+}
+
+// PasswordError содержит сообщения всех нарушенных правил
+type PasswordError struct {
+	Violations []string
+}
+
+func (e *PasswordError) Error() string {
+	return strings.Join(e.Violations, "; ")
+}
+
+// Check проверяет пароль по всем правилам. Если передан email, пароль не должен содержать его часть до '@'
+func (p PasswordPolicy) Check(password, email string) error {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("password must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("password must be at most %d characters long", p.MaxLength))
+	}
+
+	classes := passwordClasses(password)
+	if p.RequireLower && !classes.lower {
+		violations = append(violations, "password must contain a lowercase letter")
+	}
+	if p.RequireUpper && !classes.upper {
+		violations = append(violations, "password must contain an uppercase letter")
+	}
+	if p.RequireDigit && !classes.digit {
+		violations = append(violations, "password must contain a digit")
+	}
+	if p.RequireSymbol && !classes.symbol {
+		violations = append(violations, "password must contain a special character")
+	}
+
+	// слишком короткая локальная часть (например "a@b.com") встречается в любом пароле, такие не проверяем
+	if local, _, ok := strings.Cut(email, "@"); ok && utf8.RuneCountInString(local) >= 3 &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(local)) {
+		violations = append(violations, "password must not contain your email")
+	}
+
+	if p.MinEntropy > 0 && PasswordEntropy(password) < p.MinEntropy {
+		violations = append(violations, "password is too predictable, use a longer password or more kinds of characters")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordError{Violations: violations}
+	}
+	return nil
+}
+
+// PasswordEntropy грубая оценка энтропии пароля в битах: каждый новый символ дает log2 размера алфавита
+// из встреченных классов символов, повторный - только один бит
+func PasswordEntropy(password string) float64 {
+	classes := passwordClasses(password)
+
+	var pool int
+	if classes.lower {
+		pool += 26
+	}
+	if classes.upper {
+		pool += 26
+	}
+	if classes.digit {
+		pool += 10
+	}
+	if classes.symbol {
+		pool += 33
+	}
+	if classes.other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	seen := make(map[rune]bool)
+	var bits float64
+	for _, r := range password {
+		if seen[r] {
+			bits++
+			continue
+		}
+		seen[r] = true
+		bits += perChar
+	}
+	return bits
+}
+
+type charClasses struct {
+	lower, upper, digit, symbol, other bool
+}
+
+func passwordClasses(password string) charClasses {
+	var c charClasses
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			c.lower = true
+		case r >= 'A' && r <= 'Z':
+			c.upper = true
+		case r >= '0' && r <= '9':
+			c.digit = true
+		case r < utf8.RuneSelf && unicode.IsPrint(r):
+			c.symbol = true
+		case unicode.IsLower(r):
+			c.lower, c.other = true, true
+		case unicode.IsUpper(r):
+			c.upper, c.other = true, true
+		default:
+			c.other = true
+		}
+	}
+	return c
+}
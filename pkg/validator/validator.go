@@ -12,7 +12,9 @@ import (
 )
 
 var (
-	emailRegex = regexp.MustCompile(`^((([0-9A-Za-z][-0-9A-z.]{0,30}[0-9A-Za-z]?)|([0-9А-Яа-я][-0-9А-я.]{0,30}[0-9А-Яа-я]?))@([-A-Za-z]+\.)+[-A-Za-z]{2,})$`)
+	usernameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{2,31}$`)
+	localeRegex   = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8}){0,2}$`)
+	emailRegex    = regexp.MustCompile(`^((([0-9A-Za-z][-0-9A-z.]{0,30}[0-9A-Za-z]?)|([0-9А-Яа-я][-0-9А-я.]{0,30}[0-9А-Яа-я]?))@([-A-Za-z]+\.)+[-A-Za-z]{2,})$`)
 )
 
 type Validator interface {
@@ -28,6 +30,12 @@ func NewValidator() (Validator, error) {
 	if err := v.RegisterValidation("email", emailValidate); err != nil {
 		return nil, err
 	}
+	if err := v.RegisterValidation("username", usernameValidate); err != nil {
+		return nil, err
+	}
+	if err := v.RegisterValidation("locale", localeValidate); err != nil {
+		return nil, err
+	}
 	return &valid{v: v}, nil
 }
 
@@ -42,6 +50,12 @@ func validateError(err validator.FieldError) error {
 	switch err.Tag() {
 	case "email":
 		return errors.New("field email is incorrect. Make sure that you entered the email correctly and it exists")
+	case "username":
+		return errors.New("field username must be 3-32 characters long and contain only latin letters, digits, '_', '.' or '-'")
+	case "locale":
+		return errors.New("field locale must be a language tag like en, ru or pt-BR")
+	case "required_without_all":
+		return fmt.Errorf("field %s is required when %s are not set", err.Field(), err.Param())
 	default:
 		return fmt.Errorf("field %s is required", err.Field())
 	}
@@ -80,3 +94,22 @@ func emailValidate(fl validator.FieldLevel) bool {
 	}
 	return true
 }
+
+func usernameValidate(fl validator.FieldLevel) bool {
+	if fl.Field().Kind() != reflect.String {
+		return false
+	}
+	return usernameRegex.MatchString(fl.Field().String())
+}
+
+func localeValidate(fl validator.FieldLevel) bool {
+	if fl.Field().Kind() != reflect.String {
+		return false
+	}
+	return IsLocale(fl.Field().String())
+}
+
+// IsLocale похоже ли значение на языковой тег: "en", "ru", "pt-BR", "zh-Hant-TW"
+func IsLocale(s string) bool {
+	return localeRegex.MatchString(s)
+}